@@ -0,0 +1,89 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command fakesupervisor runs a function binary against a fake supervisor and
+// prints the log entries it receives.
+//
+// Usage:
+//
+//	fakesupervisor [-stop-timeout=10s] ./main [KEY=value ...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"../../nodego/supervisortest"
+)
+
+var stopTimeout = flag.Duration("stop-timeout", 10*time.Second, "how long to wait for the function to exit")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] binary [KEY=value ...]\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	s := supervisortest.NewSupervisor()
+	defer s.Close()
+
+	f, err := s.StartFunction(flag.Arg(0), flag.Args()[1:]...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("Function is listening on", f.URL)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	printed := 0
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			printed = printEntries(s, printed)
+			continue
+		case <-sigs:
+		case <-f.Done():
+		}
+		break
+	}
+
+	code, err := f.Stop(*stopTimeout)
+	printEntries(s, printed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	fmt.Printf("Function exited with code %d, %d kill call(s)\n", code, s.Kills())
+	fmt.Print(f.Output())
+}
+
+// printEntries prints the entries received after the first skip ones and
+// returns the number of entries printed so far.
+func printEntries(s *supervisortest.Supervisor, skip int) int {
+	entries := s.Entries()
+	for _, e := range entries[skip:] {
+		fmt.Printf("[%s][%s] %s\n", e.Severity, e.ExecutionID, strings.TrimSpace(e.Payload()))
+	}
+	return len(entries)
+}
@@ -0,0 +1,356 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CloudEventsSpecVersion is the version of the CloudEvents specification
+// supported by the package.
+const CloudEventsSpecVersion = "1.0"
+
+// Content types of CloudEvents.
+const (
+	jsonContentType        = "application/json"
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// cloudEventHeaderPrefix is the prefix of the headers carrying the attributes
+// of CloudEvents in binary mode.
+const cloudEventHeaderPrefix = "Ce-"
+
+// CloudEvent holds the attributes of an event received as a CloudEvent. Its
+// data is stored in Event.Data as in structured mode, whatever the mode it
+// was received in: JSON data as is, text data as a JSON string and binary
+// data as a base64 JSON string, which json.Unmarshal decodes into a []byte.
+type CloudEvent struct {
+	ID          string
+	Source      string
+	SpecVersion string
+	Type        string
+
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+
+	// Extensions holds the extension attributes, by name.
+	Extensions map[string]string
+
+	// DataBase64 tells that Event.Data holds binary data, encoded as a base64
+	// JSON string, rather than JSON or text data.
+	DataBase64 bool
+}
+
+// set sets the attribute with the given name, which must be lower case.
+func (ce *CloudEvent) set(name, value string) error {
+	switch name {
+	case "id":
+		ce.ID = value
+	case "source":
+		ce.Source = value
+	case "specversion":
+		ce.SpecVersion = value
+	case "type":
+		ce.Type = value
+	case "subject":
+		ce.Subject = value
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("invalid CloudEvent time %q", value)
+		}
+		ce.Time = t
+	case "datacontenttype":
+		ce.DataContentType = value
+	case "dataschema":
+		ce.DataSchema = value
+	default:
+		if ce.Extensions == nil {
+			ce.Extensions = map[string]string{}
+		}
+		ce.Extensions[name] = value
+	}
+	return nil
+}
+
+// validate checks that the required attributes are set.
+func (ce *CloudEvent) validate() error {
+	switch {
+	case ce.SpecVersion != CloudEventsSpecVersion:
+		return fmt.Errorf("unsupported CloudEvents specversion %q", ce.SpecVersion)
+	case ce.ID == "":
+		return errors.New("CloudEvent without id")
+	case ce.Source == "":
+		return errors.New("CloudEvent without source")
+	case ce.Type == "":
+		return errors.New("CloudEvent without type")
+	}
+	return nil
+}
+
+// attributes returns the attributes of the event carrying data of the given
+// content type, by name.
+func (ce *CloudEvent) attributes(contentType string) map[string]string {
+	attrs := map[string]string{}
+	for name, value := range ce.Extensions {
+		attrs[name] = value
+	}
+
+	attrs["id"] = ce.ID
+	attrs["source"] = ce.Source
+	attrs["specversion"] = ce.SpecVersion
+	if ce.SpecVersion == "" {
+		attrs["specversion"] = CloudEventsSpecVersion
+	}
+	attrs["type"] = ce.Type
+	if ce.Subject != "" {
+		attrs["subject"] = ce.Subject
+	}
+	if !ce.Time.IsZero() {
+		attrs["time"] = ce.Time.Format(time.RFC3339Nano)
+	}
+	if ce.DataSchema != "" {
+		attrs["dataschema"] = ce.DataSchema
+	}
+	attrs["datacontenttype"] = contentType
+	return attrs
+}
+
+// eventContext maps the attributes onto the context of legacy events. The
+// resource is the source without its service, followed by the subject, e.g.
+// //storage.googleapis.com/projects/_/buckets/b with subject objects/o
+// becomes projects/_/buckets/b/objects/o.
+func (ce *CloudEvent) eventContext() EventContext {
+	resource := ce.Source
+	if strings.HasPrefix(resource, "//") {
+		if i := strings.Index(resource[2:], "/"); i >= 0 {
+			resource = resource[2+i+1:]
+		}
+	}
+	if ce.Subject != "" {
+		resource += "/" + ce.Subject
+	}
+
+	return EventContext{
+		EventID:   ce.ID,
+		Timestamp: JSTime{ce.Time},
+		EventType: ce.Type,
+		Resource:  resource,
+	}
+}
+
+// isJSONContentType reports whether data of the given content type is JSON.
+// Data without a content type is assumed to be JSON.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return t == jsonContentType || strings.HasSuffix(t, "+json") || t == "text/json"
+}
+
+// isTextContentType reports whether data of the given content type is text.
+func isTextContentType(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(t, "text/") || t == "application/xml" || strings.HasSuffix(t, "+xml")
+}
+
+// unmarshalCloudEvent sets e from a CloudEvent in structured mode, whose
+// members are in raws.
+func (e *Event) unmarshalCloudEvent(raws map[string]json.RawMessage) error {
+	if _, ok := raws["data"]; ok {
+		if _, ok := raws["data_base64"]; ok {
+			return errors.New("CloudEvent with both data and data_base64")
+		}
+	}
+
+	ce := &CloudEvent{}
+	for name, raw := range raws {
+		switch name {
+		case "data":
+			e.Data = raw
+			continue
+		case "data_base64":
+			// Binary data stays encoded as a base64 JSON string.
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return errors.New("CloudEvent data_base64 is not a string")
+			}
+			e.Data = raw
+			ce.DataBase64 = true
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		if err := ce.set(strings.ToLower(name), value); err != nil {
+			return err
+		}
+	}
+
+	if err := ce.validate(); err != nil {
+		return err
+	}
+
+	e.Context = ce.eventContext()
+	e.CloudEvent = ce
+	return nil
+}
+
+// parseBinaryCloudEvent reads a CloudEvent in binary mode from r.
+func parseBinaryCloudEvent(r *http.Request) (*Event, error) {
+	ce := &CloudEvent{
+		DataContentType: r.Header.Get("Content-Type"),
+	}
+	for name, values := range r.Header {
+		if !strings.HasPrefix(name, cloudEventHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		if err := ce.set(strings.ToLower(strings.TrimPrefix(name, cloudEventHeaderPrefix)), value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ce.validate(); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		Context:    ce.eventContext(),
+		CloudEvent: ce,
+	}
+	// Store the data as in structured mode.
+	switch {
+	case len(body) == 0:
+	case isJSONContentType(ce.DataContentType):
+		if !json.Valid(body) {
+			return nil, errors.New("invalid JSON CloudEvent data")
+		}
+		event.Data = body
+	case isTextContentType(ce.DataContentType) && utf8.Valid(body):
+		event.Data, _ = json.Marshal(string(body))
+	default:
+		event.Data, _ = json.Marshal(body)
+		ce.DataBase64 = true
+	}
+	return event, nil
+}
+
+// ParseRequest reads the event sent by a trigger from r, which may be a
+// legacy event, a CloudEvent in binary mode (ce-* headers) or a CloudEvent in
+// structured mode (application/cloudevents+json). The attributes of
+// CloudEvents are mapped onto the context of the event and stored in
+// Event.CloudEvent.
+func ParseRequest(r *http.Request) (*Event, error) {
+	var event *Event
+	if r.Header.Get(cloudEventHeaderPrefix+"Specversion") != "" {
+		var err error
+		if event, err = parseBinaryCloudEvent(r); err != nil {
+			return nil, err
+		}
+	} else {
+		event = &Event{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			return nil, err
+		}
+	}
+
+	event.Source = eventSource(&event.Context, r.URL.Path)
+	return event, nil
+}
+
+// WriteCloudEvent responds with a CloudEvent in binary mode: the attributes of
+// ce are sent in ce-* headers and data, encoded as JSON, in the body.
+func WriteCloudEvent(w http.ResponseWriter, ce *CloudEvent, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	h := w.Header()
+	for name, value := range ce.attributes(jsonContentType) {
+		if name == "datacontenttype" {
+			continue
+		}
+		h.Set(cloudEventHeaderPrefix+name, escapeHeaderValue(value))
+	}
+	h.Set("Content-Type", jsonContentType)
+
+	_, err = w.Write(body)
+	return err
+}
+
+// WriteStructuredCloudEvent responds with a CloudEvent in structured mode: the
+// attributes of ce and data are sent as a JSON object.
+func WriteStructuredCloudEvent(w http.ResponseWriter, ce *CloudEvent, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	members := map[string]interface{}{
+		"data": json.RawMessage(body),
+	}
+	for name, value := range ce.attributes(jsonContentType) {
+		members[name] = value
+	}
+
+	w.Header().Set("Content-Type", cloudEventsContentType+"; charset=utf-8")
+	return json.NewEncoder(w).Encode(members)
+}
+
+// escapeHeaderValue percent-encodes the characters of an attribute value that
+// can't appear in a header as required by the HTTP binding of CloudEvents.
+func escapeHeaderValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < ' ' || c > '~' || c == '%' || c == '"' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,279 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// binaryRequest returns a CloudEvent in binary mode with the given data.
+func binaryRequest(contentType, body string) *http.Request {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Ce-Specversion", "1.0")
+	r.Header.Set("Ce-Id", "1234")
+	r.Header.Set("Ce-Source", "//pubsub.googleapis.com/projects/my-project/topics/my-topic")
+	r.Header.Set("Ce-Type", "google.cloud.pubsub.topic.v1.messagePublished")
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+// structuredRequest returns a CloudEvent in structured mode with the given
+// data members.
+func structuredRequest(contentType, data string) *http.Request {
+	members := []string{
+		`"specversion": "1.0"`,
+		`"id": "1234"`,
+		`"source": "//pubsub.googleapis.com/projects/my-project/topics/my-topic"`,
+		`"type": "google.cloud.pubsub.topic.v1.messagePublished"`,
+	}
+	if contentType != "" {
+		members = append(members, `"datacontenttype": "`+contentType+`"`)
+	}
+	if data != "" {
+		members = append(members, data)
+	}
+	r := httptest.NewRequest("POST", "/", strings.NewReader("{"+strings.Join(members, ", ")+"}"))
+	r.Header.Set("Content-Type", cloudEventsContentType)
+	return r
+}
+
+func TestParseBinaryCloudEventData(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		base64      bool
+	}{
+		{"JSON", "application/json", `{"a": 1}`, `{"a": 1}`, false},
+		{"JSON with parameters", "application/json; charset=utf-8", `[1, 2]`, `[1, 2]`, false},
+		{"JSON suffix", "application/vnd.example+json", `"s"`, `"s"`, false},
+		{"no content type", "", `{"a": 1}`, `{"a": 1}`, false},
+		{"text", "text/plain", "hello", `"hello"`, false},
+		{"text with parameters", "text/plain; charset=utf-8", "héllo\n", `"héllo\n"`, false},
+		{"XML", "application/xml", "<a/>", `"\u003ca/\u003e"`, false},
+		{"binary", "application/octet-stream", "\x00\x01\xff", `"AAH/"`, true},
+		{"invalid UTF-8 text", "text/plain", "\xff", `"/w=="`, true},
+		{"empty", "text/plain", "", "", false},
+	}
+	for _, tt := range tests {
+		e, err := ParseRequest(binaryRequest(tt.contentType, tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(e.Data) != tt.want {
+			t.Errorf("%s: got data %s, want %s", tt.name, e.Data, tt.want)
+		}
+		if e.CloudEvent.DataBase64 != tt.base64 {
+			t.Errorf("%s: got DataBase64 %v, want %v", tt.name, e.CloudEvent.DataBase64, tt.base64)
+		}
+	}
+
+	if _, err := ParseRequest(binaryRequest("application/json", "{")); err == nil {
+		t.Error("invalid JSON data was accepted")
+	}
+}
+
+func TestParseStructuredCloudEventData(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		want        string
+		base64      bool
+	}{
+		{"JSON", "application/json", `"data": {"a": 1}`, `{"a": 1}`, false},
+		{"no content type", "", `"data": {"a": 1}`, `{"a": 1}`, false},
+		{"text", "text/plain", `"data": "hello"`, `"hello"`, false},
+		{"binary", "application/octet-stream", `"data_base64": "AAH/"`, `"AAH/"`, true},
+		{"no data", "", "", "", false},
+	}
+	for _, tt := range tests {
+		e, err := ParseRequest(structuredRequest(tt.contentType, tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(e.Data) != tt.want {
+			t.Errorf("%s: got data %s, want %s", tt.name, e.Data, tt.want)
+		}
+		if e.CloudEvent.DataBase64 != tt.base64 {
+			t.Errorf("%s: got DataBase64 %v, want %v", tt.name, e.CloudEvent.DataBase64, tt.base64)
+		}
+	}
+
+	for _, data := range []string{
+		`"data": "hello", "data_base64": "aGVsbG8="`,
+		`"data_base64": 12`,
+	} {
+		if _, err := ParseRequest(structuredRequest("", data)); err == nil {
+			t.Errorf("%s was accepted", data)
+		}
+	}
+}
+
+func TestCloudEventDataIsTheSameInBothModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		data        string
+	}{
+		{"JSON", "application/json", `{"a":1}`, `"data": {"a":1}`},
+		{"text", "text/plain", "hello", `"data": "hello"`},
+		{"binary", "application/octet-stream", "hello", `"data_base64": "aGVsbG8="`},
+	}
+	for _, tt := range tests {
+		binary, err := ParseRequest(binaryRequest(tt.contentType, tt.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		structured, err := ParseRequest(structuredRequest(tt.contentType, tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if string(binary.Data) != string(structured.Data) {
+			t.Errorf("%s: got data %s in binary mode and %s in structured mode", tt.name, binary.Data, structured.Data)
+		}
+		if binary.CloudEvent.DataBase64 != structured.CloudEvent.DataBase64 {
+			t.Errorf("%s: got DataBase64 %v in binary mode and %v in structured mode", tt.name, binary.CloudEvent.DataBase64, structured.CloudEvent.DataBase64)
+		}
+	}
+}
+
+func TestParseBinaryCloudEventAttributes(t *testing.T) {
+	r := binaryRequest("application/json", `{}`)
+	r.Header.Set("Ce-Source", "//storage.googleapis.com/projects/_/buckets/my-bucket")
+	r.Header.Set("Ce-Type", "google.cloud.storage.object.v1.finalized")
+	r.Header.Set("Ce-Subject", "objects/a%20b.txt")
+	r.Header.Set("Ce-Time", "2018-03-22T18:07:44.384Z")
+	r.Header.Set("Ce-Traceparent", "00-abc-def-01")
+
+	e, err := ParseRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTime := time.Date(2018, 3, 22, 18, 7, 44, 384*int(time.Millisecond), time.UTC)
+	want := &CloudEvent{
+		ID:              "1234",
+		Source:          "//storage.googleapis.com/projects/_/buckets/my-bucket",
+		SpecVersion:     "1.0",
+		Type:            "google.cloud.storage.object.v1.finalized",
+		Subject:         "objects/a b.txt",
+		Time:            wantTime,
+		DataContentType: "application/json",
+		Extensions:      map[string]string{"traceparent": "00-abc-def-01"},
+	}
+	if !reflect.DeepEqual(e.CloudEvent, want) {
+		t.Errorf("got %+v, want %+v", e.CloudEvent, want)
+	}
+
+	wantContext := EventContext{
+		EventID:   "1234",
+		Timestamp: JSTime{wantTime},
+		EventType: "google.cloud.storage.object.v1.finalized",
+		Resource:  "projects/_/buckets/my-bucket/objects/a b.txt",
+	}
+	if e.Context != wantContext {
+		t.Errorf("got context %+v, want %+v", e.Context, wantContext)
+	}
+	if e.Source.Kind != KindStorage || e.Source.Bucket != "my-bucket" || e.Source.Object != "a b.txt" {
+		t.Errorf("got source %+v", e.Source)
+	}
+}
+
+func TestParseInvalidCloudEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"specversion", "Ce-Specversion", "0.3"},
+		{"id", "Ce-Id", ""},
+		{"source", "Ce-Source", ""},
+		{"type", "Ce-Type", ""},
+		{"time", "Ce-Time", "yesterday"},
+	}
+	for _, tt := range tests {
+		r := binaryRequest("application/json", `{}`)
+		if tt.value == "" {
+			r.Header.Del(tt.header)
+		} else {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if _, err := ParseRequest(r); err == nil {
+			t.Errorf("%s: the event was accepted", tt.name)
+		}
+	}
+}
+
+func TestWriteCloudEventRoundTrip(t *testing.T) {
+	ce := &CloudEvent{
+		ID:         "5678",
+		Source:     "//example.com/projects/my-project/topics/my-topic",
+		Type:       "com.example.reply",
+		Subject:    "naïve \"subject\" 100%",
+		Time:       time.Date(2018, 3, 22, 18, 7, 44, 0, time.UTC),
+		DataSchema: "https://example.com/schema",
+		Extensions: map[string]string{"traceparent": "00-abc-def-01"},
+	}
+	data := map[string]interface{}{"text": "hello", "count": float64(2)}
+
+	writers := []struct {
+		name  string
+		write func(http.ResponseWriter, *CloudEvent, interface{}) error
+	}{
+		{"binary", WriteCloudEvent},
+		{"structured", WriteStructuredCloudEvent},
+	}
+	for _, w := range writers {
+		rec := httptest.NewRecorder()
+		if err := w.write(rec, ce, data); err != nil {
+			t.Fatalf("%s: %v", w.name, err)
+		}
+
+		r := httptest.NewRequest("POST", "/", rec.Body)
+		r.Header = rec.Header()
+		e, err := ParseRequest(r)
+		if err != nil {
+			t.Fatalf("%s: %v", w.name, err)
+		}
+
+		want := *ce
+		want.SpecVersion = CloudEventsSpecVersion
+		want.DataContentType = jsonContentType
+		if !reflect.DeepEqual(e.CloudEvent, &want) {
+			t.Errorf("%s: got %+v, want %+v", w.name, e.CloudEvent, &want)
+		}
+
+		var got map[string]interface{}
+		if err := json.Unmarshal(e.Data, &got); err != nil {
+			t.Errorf("%s: invalid data %s: %v", w.name, e.Data, err)
+		} else if !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: got data %v, want %v", w.name, got, data)
+		}
+	}
+}
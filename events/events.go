@@ -15,8 +15,10 @@
 package events
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"runtime/debug"
 	"time"
@@ -39,7 +41,8 @@ func (t *JSTime) UnmarshalJSON(b []byte) (err error) {
 		return
 	}
 
-	t.Time, err = time.Parse(`"2006-01-02T15:04:05.000Z"`, string(b))
+	// Firestore events have microseconds, other events milliseconds.
+	t.Time, err = time.Parse(`"`+time.RFC3339Nano+`"`, string(b))
 	return
 }
 
@@ -57,6 +60,14 @@ type EventContext struct {
 type Event struct {
 	Context EventContext
 	Data    json.RawMessage
+
+	// Source is where the event comes from, filled in by Handler from the
+	// context of the event and the request path.
+	Source EventSource `json:"-"`
+
+	// CloudEvent holds the attributes of the event if it was received as a
+	// CloudEvent, or is nil.
+	CloudEvent *CloudEvent `json:"-"`
 }
 
 // UnmarshalJSON parses a JSON string to time.Time.
@@ -66,6 +77,10 @@ func (e *Event) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	if _, ok := raws["specversion"]; ok {
+		return e.unmarshalCloudEvent(raws)
+	}
+
 	rawContext, ok := raws["context"]
 	if !ok {
 		rawContext = b
@@ -86,10 +101,21 @@ type PubSubMessage struct {
 	Data []byte
 }
 
-// PubSubMessage unmarshals the event data as a pub sub message.
+// PubSubMessage unmarshals the event data as a pub sub message. The data of
+// CloudEvents, which wraps the message along with the subscription, is
+// supported too.
 func (e *Event) PubSubMessage() (*PubSubMessage, error) {
+	var wrapper struct {
+		Message *pubsub.PubsubMessage `json:"message"`
+	}
+	if err := json.Unmarshal(e.Data, &wrapper); err != nil {
+		return nil, err
+	}
+
 	var msg pubsub.PubsubMessage
-	if err := json.Unmarshal(e.Data, &msg); err != nil {
+	if wrapper.Message != nil {
+		msg = *wrapper.Message
+	} else if err := json.Unmarshal(e.Data, &msg); err != nil {
 		return nil, err
 	}
 
@@ -104,11 +130,18 @@ func (e *Event) PubSubMessage() (*PubSubMessage, error) {
 	}, nil
 }
 
-// StorageObject is a wrapper for storage.Object.
+// StorageObject is a wrapper for storage.Object. Generation and
+// Metageneration are already parsed as int64 by storage.Object.
 type StorageObject struct {
 	storage.Object
 
-	// TODO consider adding pre-parsed time fields.
+	// Kind is the kind of change that fired the event.
+	Kind StorageEventKind
+
+	// Pre-parsed fields of storage.Object.
+	TimeCreated time.Time
+	Updated     time.Time
+	Size        int64
 }
 
 // StorageObject unmarshals the event data as a storage event.
@@ -118,46 +151,118 @@ func (e *Event) StorageObject() (*StorageObject, error) {
 		return nil, err
 	}
 
-	return &StorageObject{
+	so := &StorageObject{
 		Object: obj,
-	}, nil
+		Kind:   ParseStorageEventKind(e.Context.EventType),
+		Size:   int64(obj.Size),
+	}
+
+	if e.Context.EventType == legacyStorageEventType {
+		var state struct {
+			ResourceState string `json:"resourceState"`
+		}
+		if err := json.Unmarshal(e.Data, &state); err != nil {
+			return nil, err
+		}
+		so.Kind = legacyStorageEventKind(state.ResourceState, obj.Metageneration)
+	}
+
+	var err error
+	if so.TimeCreated, err = parseStorageTime("timeCreated", obj.TimeCreated); err != nil {
+		return nil, err
+	}
+	if so.Updated, err = parseStorageTime("updated", obj.Updated); err != nil {
+		return nil, err
+	}
+	return so, nil
 }
 
-// Handler returns http.Handler that parses the body for a function event.
+// Handler returns http.Handler that parses the body for a function event. See
+// ParseRequest for the supported formats.
+//
+// Logs written during the execution are delivered to the supervisor before
+// the response is sent. Errors returned by handler are reported as "error" in
+// the X-Google-Status header, panics as "crash".
 func Handler(handler func(*Event) error) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO potentially extract information from the request path.
-		//
-		// PubSub and Bucket triggers have the following request path
-		// structures respectively:
-		//
-		// /execute/_ah/push-handlers/pubsub/projects/{PROJECT_NAME}/topics/{TOPIC_NAME}
-		// /execute/_ah/push-handlers/pubsub/projects/{ARBITRARY_VALUE}/topics/{ARBITRARY_VALUE}
-		//
-		// It seems that, for the time being, bucket triggers are actually just
-		// pubsub triggers internally.
-
-		// TODO flush logs before sending response, as in worker.js
+	return eventHandler(func(ctx context.Context, event *Event) error {
+		return handler(event)
+	})
+}
+
+// eventHandler returns http.Handler that parses the body for a function event
+// and passes it to handler, with the request context carrying the event.
+func eventHandler(handler func(context.Context, *Event) error) http.HandlerFunc {
+	return nodego.WithLoggerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errorLogger := nodego.ErrorLoggerFromContext(r.Context())
 
 		defer func() {
 			if r := recover(); r != nil {
+				nodego.SetExecutionStatus(w, nodego.StatusCrash)
 				w.WriteHeader(http.StatusInternalServerError)
-				nodego.ErrorLogger.Printf("%s:\n\n%s\n", r, debug.Stack())
+				errorLogger.Printf("%s:\n\n%s\n", r, debug.Stack())
 			}
 		}()
 
 		defer r.Body.Close()
 
-		var event Event
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			nodego.ErrorLogger.Print("Failed to decode event: ", err)
+		event, err := ParseRequest(r)
+		if err != nil {
+			errorLogger.Print("Failed to decode event: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		if err := handler(&event); err != nil {
-			nodego.ErrorLogger.Print(err)
+		ctx := context.WithValue(r.Context(), eventKey{}, event)
+		if err := handler(ctx, event); err != nil {
+			errorLogger.Print(err)
+			nodego.SetExecutionStatus(w, nodego.StatusError)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	}
+	})
+}
+
+type eventKey struct{}
+
+// FromContext returns the event carried by the context passed to the handlers
+// of PubSubHandler, StorageHandler and TypedHandler, or nil.
+func FromContext(ctx context.Context) *Event {
+	e, _ := ctx.Value(eventKey{}).(*Event)
+	return e
+}
+
+// PubSubHandler is like Handler, but passes the event data decoded as a pub
+// sub message to handler. The context carries the execution ID and the event.
+func PubSubHandler(handler func(context.Context, *PubSubMessage) error) http.HandlerFunc {
+	return eventHandler(func(ctx context.Context, event *Event) error {
+		msg, err := event.PubSubMessage()
+		if err != nil {
+			return fmt.Errorf("failed to decode pub sub message: %v", err)
+		}
+		return handler(ctx, msg)
+	})
+}
+
+// StorageHandler is like Handler, but passes the event data decoded as a
+// storage object to handler. The context carries the execution ID and the
+// event.
+func StorageHandler(handler func(context.Context, *StorageObject) error) http.HandlerFunc {
+	return eventHandler(func(ctx context.Context, event *Event) error {
+		obj, err := event.StorageObject()
+		if err != nil {
+			return fmt.Errorf("failed to decode storage object: %v", err)
+		}
+		return handler(ctx, obj)
+	})
+}
+
+// TypedHandler is like Handler, but passes the event data decoded as JSON
+// into a T to handler. The context carries the execution ID and the event.
+func TypedHandler[T any](handler func(context.Context, *T) error) http.HandlerFunc {
+	return eventHandler(func(ctx context.Context, event *Event) error {
+		v := new(T)
+		if err := json.Unmarshal(event.Data, v); err != nil {
+			return fmt.Errorf("failed to decode event data: %v", err)
+		}
+		return handler(ctx, v)
+	})
 }
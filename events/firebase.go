@@ -0,0 +1,133 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DatabaseEvent is the data of a Firebase Realtime Database trigger.
+type DatabaseEvent struct {
+	// Data is the value at the reference before the change, or null.
+	Data json.RawMessage `json:"data"`
+	// Delta is the change written at the reference, or null if it was
+	// deleted.
+	Delta json.RawMessage `json:"delta"`
+
+	// Instance is the database instance and Path the reference that changed,
+	// e.g. /users/u1, parsed from the resource of the event.
+	Instance string `json:"-"`
+	Path     string `json:"-"`
+}
+
+// DatabaseEvent unmarshals the event data as a Realtime Database change.
+func (e *Event) DatabaseEvent() (*DatabaseEvent, error) {
+	var de DatabaseEvent
+	if err := json.Unmarshal(e.Data, &de); err != nil {
+		return nil, err
+	}
+
+	s := e.Context.Source()
+	de.Instance = s.Instance
+	de.Path = s.Path
+	return &de, nil
+}
+
+// Deleted reports whether the change deleted the reference.
+func (d *DatabaseEvent) Deleted() bool {
+	return isNull(d.Delta)
+}
+
+// Params extracts the parameters of the path of the reference given the
+// pattern of the trigger, e.g. /users/{uid}/messages/{messageId}. Wildcards
+// only match a single path segment.
+func (d *DatabaseEvent) Params(pattern string) (map[string]string, error) {
+	params, ok := matchPath(pattern, d.Path)
+	if !ok {
+		return nil, fmt.Errorf("path %q does not match %q", d.Path, pattern)
+	}
+	return params, nil
+}
+
+// matchPath matches path against a pattern with {name} wildcards, returning
+// the values of the wildcards.
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, p := range patternParts {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			params[p[1:len(p)-1]] = pathParts[i]
+			continue
+		}
+		if p != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// isNull reports whether raw is missing or the JSON null value.
+func isNull(raw json.RawMessage) bool {
+	s := strings.TrimSpace(string(raw))
+	return s == "" || s == "null"
+}
+
+// AuthEvent is the data of a Firebase Authentication trigger: the user that
+// was created or deleted.
+type AuthEvent struct {
+	UID           string                 `json:"uid"`
+	Email         string                 `json:"email"`
+	EmailVerified bool                   `json:"emailVerified"`
+	DisplayName   string                 `json:"displayName"`
+	PhotoURL      string                 `json:"photoURL"`
+	PhoneNumber   string                 `json:"phoneNumber"`
+	Disabled      bool                   `json:"disabled"`
+	Metadata      AuthMetadata           `json:"metadata"`
+	ProviderData  []AuthProviderInfo     `json:"providerData"`
+	CustomClaims  map[string]interface{} `json:"customClaims"`
+}
+
+// AuthMetadata holds when a user was created and last signed in.
+type AuthMetadata struct {
+	CreatedAt      time.Time `json:"createdAt"`
+	LastSignedInAt time.Time `json:"lastSignedInAt"`
+}
+
+// AuthProviderInfo describes how a user signs in with an identity provider.
+type AuthProviderInfo struct {
+	UID         string `json:"uid"`
+	ProviderID  string `json:"providerId"`
+	Email       string `json:"email"`
+	DisplayName string `json:"displayName"`
+	PhotoURL    string `json:"photoURL"`
+	PhoneNumber string `json:"phoneNumber"`
+}
+
+// AuthEvent unmarshals the event data as a Firebase Authentication user.
+func (e *Event) AuthEvent() (*AuthEvent, error) {
+	var ae AuthEvent
+	if err := json.Unmarshal(e.Data, &ae); err != nil {
+		return nil, err
+	}
+	return &ae, nil
+}
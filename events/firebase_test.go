@@ -0,0 +1,250 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Legacy payloads, as sent to functions by Firebase triggers.
+const (
+	databaseCreatePayload = `{
+		"eventId": "Qx2fsMLB0LV8Ib+NrhNcySBD0K4=",
+		"timestamp": "2018-03-22T18:07:44.384Z",
+		"eventType": "providers/google.firebase.database/eventTypes/ref.write",
+		"resource": "projects/_/instances/my-project-db/refs/rooms/lobby/messages/-L8F2eX3",
+		"auth": {"admin": false, "variable": {"uid": "Kd9VbT1u"}},
+		"data": {
+			"data": null,
+			"delta": {"author": "Kd9VbT1u", "text": "Hello"}
+		}
+	}`
+
+	databaseDeletePayload = `{
+		"eventId": "bM0LqWN8ho3R5AGq4zW9T1FhL5k=",
+		"timestamp": "2018-03-22T18:09:02.117Z",
+		"eventType": "providers/google.firebase.database/eventTypes/ref.write",
+		"resource": "projects/_/instances/my-project-db/refs/rooms/lobby/messages/-L8F2eX3",
+		"auth": {"admin": true},
+		"data": {
+			"data": {"author": "Kd9VbT1u", "text": "Hello"},
+			"delta": null
+		}
+	}`
+
+	authCreatePayload = `{
+		"eventId": "3ad5d4c1-94a0-4e0b-9d6a-3f1f0c2e5b7a",
+		"timestamp": "2018-03-22T18:12:30.505Z",
+		"eventType": "providers/firebase.auth/eventTypes/user.create",
+		"resource": "projects/my-project",
+		"data": {
+			"uid": "Kd9VbT1u",
+			"email": "jane@example.com",
+			"emailVerified": false,
+			"displayName": "Jane Doe",
+			"photoURL": "https://example.com/jane.png",
+			"metadata": {
+				"createdAt": "2018-03-22T18:12:30Z",
+				"lastSignedInAt": "2018-03-22T18:12:30.5Z"
+			},
+			"providerData": [{
+				"uid": "jane@example.com",
+				"providerId": "password",
+				"email": "jane@example.com",
+				"displayName": "Jane Doe",
+				"photoURL": "https://example.com/jane.png"
+			}]
+		}
+	}`
+
+	authDeletePayload = `{
+		"eventId": "8c1e4f0b-5f7b-4b8e-a1f5-0e6c2d9b3a41",
+		"timestamp": "2018-03-23T09:00:00.000Z",
+		"eventType": "providers/firebase.auth/eventTypes/user.delete",
+		"resource": "projects/my-project",
+		"data": {
+			"uid": "Pq7WzX2c",
+			"phoneNumber": "+15555550100",
+			"disabled": true,
+			"metadata": {"createdAt": "2017-11-02T10:15:00Z"},
+			"providerData": [{"uid": "+15555550100", "providerId": "phone", "phoneNumber": "+15555550100"}],
+			"customClaims": {"admin": true}
+		}
+	}`
+)
+
+// parseEvent parses a legacy payload.
+func parseEvent(t *testing.T, payload string) *Event {
+	t.Helper()
+
+	var e Event
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestDatabaseEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		deleted   bool
+		wantData  string
+		wantDelta string
+	}{
+		{"create", databaseCreatePayload, false, "null", `{"author": "Kd9VbT1u", "text": "Hello"}`},
+		{"delete", databaseDeletePayload, true, `{"author": "Kd9VbT1u", "text": "Hello"}`, "null"},
+	}
+	for _, tt := range tests {
+		e := parseEvent(t, tt.payload)
+		if kind := e.Context.Source().Kind; kind != KindDatabase {
+			t.Errorf("%s: got kind %q, want %q", tt.name, kind, KindDatabase)
+		}
+
+		de, err := e.DatabaseEvent()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if de.Instance != "my-project-db" {
+			t.Errorf("%s: got instance %q, want %q", tt.name, de.Instance, "my-project-db")
+		}
+		if want := "/rooms/lobby/messages/-L8F2eX3"; de.Path != want {
+			t.Errorf("%s: got path %q, want %q", tt.name, de.Path, want)
+		}
+		if de.Deleted() != tt.deleted {
+			t.Errorf("%s: got Deleted() = %v, want %v", tt.name, de.Deleted(), tt.deleted)
+		}
+		if string(de.Data) != tt.wantData {
+			t.Errorf("%s: got data %s, want %s", tt.name, de.Data, tt.wantData)
+		}
+		if string(de.Delta) != tt.wantDelta {
+			t.Errorf("%s: got delta %s, want %s", tt.name, de.Delta, tt.wantDelta)
+		}
+	}
+}
+
+func TestDatabaseEventDeletedWithoutDelta(t *testing.T) {
+	de := &DatabaseEvent{Data: json.RawMessage(`{"text": "Hello"}`)}
+	if !de.Deleted() {
+		t.Error("a change without delta is not reported as deleted")
+	}
+}
+
+func TestDatabaseEventParams(t *testing.T) {
+	de, err := parseEvent(t, databaseCreatePayload).DatabaseEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    map[string]string
+	}{
+		{"/rooms/{roomId}/messages/{messageId}", map[string]string{"roomId": "lobby", "messageId": "-L8F2eX3"}},
+		{"rooms/{roomId}/messages/{messageId}/", map[string]string{"roomId": "lobby", "messageId": "-L8F2eX3"}},
+		{"/rooms/lobby/messages/{messageId}", map[string]string{"messageId": "-L8F2eX3"}},
+		{"/rooms/lobby/messages/-L8F2eX3", map[string]string{}},
+		// Patterns that don't match.
+		{"/rooms/{roomId}", nil},
+		{"/rooms/{roomId}/messages/{messageId}/likes/{uid}", nil},
+		{"/chats/{roomId}/messages/{messageId}", nil},
+		{"/rooms/kitchen/messages/{messageId}", nil},
+	}
+	for _, tt := range tests {
+		got, err := de.Params(tt.pattern)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("Params(%q) = %v, want an error", tt.pattern, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Params(%q): %v", tt.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Params(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestAuthEventCreate(t *testing.T) {
+	e := parseEvent(t, authCreatePayload)
+	if kind := e.Context.Source().Kind; kind != KindAuth {
+		t.Errorf("got kind %q, want %q", kind, KindAuth)
+	}
+
+	ae, err := e.AuthEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ae.UID != "Kd9VbT1u" || ae.Email != "jane@example.com" || ae.DisplayName != "Jane Doe" || ae.EmailVerified {
+		t.Errorf("got user %+v", ae)
+	}
+	if want := time.Date(2018, 3, 22, 18, 12, 30, 0, time.UTC); !ae.Metadata.CreatedAt.Equal(want) {
+		t.Errorf("got CreatedAt %v, want %v", ae.Metadata.CreatedAt, want)
+	}
+	if want := time.Date(2018, 3, 22, 18, 12, 30, 500*int(time.Millisecond), time.UTC); !ae.Metadata.LastSignedInAt.Equal(want) {
+		t.Errorf("got LastSignedInAt %v, want %v", ae.Metadata.LastSignedInAt, want)
+	}
+
+	wantProviders := []AuthProviderInfo{{
+		UID:         "jane@example.com",
+		ProviderID:  "password",
+		Email:       "jane@example.com",
+		DisplayName: "Jane Doe",
+		PhotoURL:    "https://example.com/jane.png",
+	}}
+	if !reflect.DeepEqual(ae.ProviderData, wantProviders) {
+		t.Errorf("got provider data %+v, want %+v", ae.ProviderData, wantProviders)
+	}
+}
+
+func TestAuthEventDelete(t *testing.T) {
+	e := parseEvent(t, authDeletePayload)
+	if kind := e.Context.Source().Kind; kind != KindAuth {
+		t.Errorf("got kind %q, want %q", kind, KindAuth)
+	}
+
+	ae, err := e.AuthEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ae.UID != "Pq7WzX2c" || ae.PhoneNumber != "+15555550100" || !ae.Disabled {
+		t.Errorf("got user %+v", ae)
+	}
+	if want := time.Date(2017, 11, 2, 10, 15, 0, 0, time.UTC); !ae.Metadata.CreatedAt.Equal(want) {
+		t.Errorf("got CreatedAt %v, want %v", ae.Metadata.CreatedAt, want)
+	}
+	if !ae.Metadata.LastSignedInAt.IsZero() {
+		t.Errorf("got LastSignedInAt %v for a user who never signed in", ae.Metadata.LastSignedInAt)
+	}
+	if admin, _ := ae.CustomClaims["admin"].(bool); !admin {
+		t.Errorf("got custom claims %v", ae.CustomClaims)
+	}
+}
+
+func TestAuthEventInvalidTime(t *testing.T) {
+	e := &Event{Data: json.RawMessage(`{"uid": "u", "metadata": {"createdAt": "yesterday"}}`)}
+	if _, err := e.AuthEvent(); err == nil {
+		t.Error("an invalid creation time was accepted")
+	}
+}
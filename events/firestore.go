@@ -0,0 +1,386 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FirestoreEvent is the data of a Firestore document trigger.
+type FirestoreEvent struct {
+	// OldValue is the document before the change, empty if it was created.
+	OldValue FirestoreDocument `json:"oldValue"`
+	// Value is the document after the change, empty if it was deleted.
+	Value FirestoreDocument `json:"value"`
+	// UpdateMask lists the fields changed by an update.
+	UpdateMask FirestoreUpdateMask `json:"updateMask"`
+}
+
+// FirestoreUpdateMask lists the fields changed by an update.
+type FirestoreUpdateMask struct {
+	FieldPaths []string `json:"fieldPaths"`
+}
+
+// FirestoreDocument is a Firestore document in the encoding of the Firestore
+// API.
+type FirestoreDocument struct {
+	// Name is the full resource name of the document, e.g.
+	// projects/{project}/databases/{database}/documents/{path}.
+	Name       string                    `json:"name"`
+	Fields     map[string]FirestoreValue `json:"fields"`
+	CreateTime time.Time                 `json:"createTime"`
+	UpdateTime time.Time                 `json:"updateTime"`
+}
+
+// FirestoreValue is a field value in the typed encoding of the Firestore API,
+// e.g. {"integerValue": "42"}.
+type FirestoreValue struct {
+	raw map[string]json.RawMessage
+}
+
+// FirestoreReference is the resource name of a document referenced by a
+// field.
+type FirestoreReference string
+
+// LatLng is a geographic point.
+type LatLng struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// FirestoreEvent unmarshals the event data as a Firestore document change.
+func (e *Event) FirestoreEvent() (*FirestoreEvent, error) {
+	var fe FirestoreEvent
+	if err := json.Unmarshal(e.Data, &fe); err != nil {
+		return nil, err
+	}
+	return &fe, nil
+}
+
+// Exists reports whether the document exists, i.e. whether it is not the
+// old value of a created document or the value of a deleted one.
+func (d *FirestoreDocument) Exists() bool {
+	return d.Name != ""
+}
+
+// Data returns the fields of the document converted to Go types as by
+// FirestoreValue.Interface.
+func (d *FirestoreDocument) Data() (map[string]interface{}, error) {
+	return convertFields(d.Fields)
+}
+
+// DataTo decodes the fields of the document into v, which must be a pointer
+// to a struct or a map with string keys. Struct fields are matched by the
+// name in their firestore tag, e.g. `firestore:"displayName"`, or by their
+// name if they have none. Fields tagged with "-" are skipped.
+func (d *FirestoreDocument) DataTo(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("events: DataTo needs a non-nil pointer")
+	}
+
+	data, err := d.Data()
+	if err != nil {
+		return err
+	}
+	return assignValue(rv.Elem(), data)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.UnmarshalJSON.
+func (v *FirestoreValue) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &v.raw)
+}
+
+// MarshalJSON implements json.Marshaler.MarshalJSON.
+func (v FirestoreValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.raw)
+}
+
+// Kind returns the name of the type of the value in the Firestore API, e.g.
+// "stringValue", or an empty string for an unset value.
+func (v FirestoreValue) Kind() string {
+	for k := range v.raw {
+		if strings.HasSuffix(k, "Value") {
+			return k
+		}
+	}
+	return ""
+}
+
+// Interface converts the value to a Go type:
+//
+//	nullValue       nil
+//	booleanValue    bool
+//	integerValue    int64
+//	doubleValue     float64
+//	timestampValue  time.Time
+//	stringValue     string
+//	bytesValue      []byte
+//	referenceValue  FirestoreReference
+//	geoPointValue   LatLng
+//	arrayValue      []interface{}
+//	mapValue        map[string]interface{}
+func (v FirestoreValue) Interface() (interface{}, error) {
+	kind := v.Kind()
+	raw := v.raw[kind]
+
+	switch kind {
+	case "", "nullValue":
+		return nil, nil
+	case "booleanValue":
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		return b, err
+	case "integerValue":
+		// 64-bit integers are encoded as strings.
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(string(n), 10, 64)
+	case "doubleValue":
+		return parseDouble(raw)
+	case "timestampValue":
+		var t time.Time
+		err := json.Unmarshal(raw, &t)
+		return t, err
+	case "stringValue":
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case "bytesValue":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(s)
+	case "referenceValue":
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return FirestoreReference(s), err
+	case "geoPointValue":
+		var p LatLng
+		err := json.Unmarshal(raw, &p)
+		return p, err
+	case "arrayValue":
+		var a struct {
+			Values []FirestoreValue `json:"values"`
+		}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(a.Values))
+		for i, av := range a.Values {
+			var err error
+			if values[i], err = av.Interface(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case "mapValue":
+		var m struct {
+			Fields map[string]FirestoreValue `json:"fields"`
+		}
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, err
+		}
+		return convertFields(m.Fields)
+	}
+	return nil, fmt.Errorf("unknown Firestore value type %q", kind)
+}
+
+// parseDouble parses a double, which is a JSON number or one of the strings
+// "NaN", "Infinity" and "-Infinity".
+func parseDouble(raw json.RawMessage) (float64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		var f float64
+		err := json.Unmarshal(raw, &f)
+		return f, err
+	}
+
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// convertFields converts the fields of a document or map to Go types.
+func convertFields(fields map[string]FirestoreValue) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(fields))
+	for name, v := range fields {
+		converted, err := v.Interface()
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", name, err)
+		}
+		m[name] = converted
+	}
+	return m, nil
+}
+
+// assignValue stores src, as returned by FirestoreValue.Interface, in dst.
+func assignValue(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assignValue(dst.Elem(), src)
+	case reflect.Interface:
+		if dst.NumMethod() == 0 {
+			dst.Set(reflect.ValueOf(src))
+			return nil
+		}
+	}
+
+	switch s := src.(type) {
+	case map[string]interface{}:
+		switch dst.Kind() {
+		case reflect.Struct:
+			return assignStruct(dst, s)
+		case reflect.Map:
+			if dst.Type().Key().Kind() != reflect.String {
+				break
+			}
+			m := reflect.MakeMapWithSize(dst.Type(), len(s))
+			for k, v := range s {
+				elem := reflect.New(dst.Type().Elem()).Elem()
+				if err := assignValue(elem, v); err != nil {
+					return fmt.Errorf("%s: %v", k, err)
+				}
+				m.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+			}
+			dst.Set(m)
+			return nil
+		}
+	case []interface{}:
+		switch dst.Kind() {
+		case reflect.Slice:
+			sl := reflect.MakeSlice(dst.Type(), len(s), len(s))
+			for i, v := range s {
+				if err := assignValue(sl.Index(i), v); err != nil {
+					return fmt.Errorf("[%d]: %v", i, err)
+				}
+			}
+			dst.Set(sl)
+			return nil
+		case reflect.Array:
+			for i := 0; i < dst.Len(); i++ {
+				var v interface{}
+				if i < len(s) {
+					v = s[i]
+				}
+				if err := assignValue(dst.Index(i), v); err != nil {
+					return fmt.Errorf("[%d]: %v", i, err)
+				}
+			}
+			return nil
+		}
+	case int64:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(s) {
+				return fmt.Errorf("%d overflows %s", s, dst.Type())
+			}
+			dst.SetInt(s)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if s < 0 || dst.OverflowUint(uint64(s)) {
+				return fmt.Errorf("%d overflows %s", s, dst.Type())
+			}
+			dst.SetUint(uint64(s))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(s))
+			return nil
+		}
+	case float64:
+		switch dst.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(s)
+			return nil
+		}
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+	// Allows e.g. references into strings, but not integers into strings.
+	if sv.Kind() == dst.Kind() && sv.Type().ConvertibleTo(dst.Type()) {
+		dst.Set(sv.Convert(dst.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+}
+
+// assignStruct stores the fields of m in the matching fields of dst.
+func assignStruct(dst reflect.Value, m map[string]interface{}) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, tagged := f.Tag.Lookup("firestore")
+		if !tagged && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			// Embedded structs without a tag are inlined, even unexported
+			// ones as with encoding/json.
+			if err := assignStruct(dst.Field(i), m); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tagged {
+			tag = strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+
+		v, ok := m[name]
+		if !ok {
+			continue
+		}
+		if err := assignValue(dst.Field(i), v); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
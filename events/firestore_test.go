@@ -0,0 +1,353 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// firestoreUpdatePayload is a legacy payload, as sent to functions by a
+// Firestore trigger, holding every type of value.
+const firestoreUpdatePayload = `{
+	"eventId": "7b8f1c2e-4d5a-4e6b-8c9d-0a1b2c3d4e5f-0",
+	"timestamp": "2018-03-22T18:20:11.123456Z",
+	"eventType": "providers/cloud.firestore/eventTypes/document.write",
+	"resource": "projects/my-project/databases/(default)/documents/users/u1",
+	"data": {
+		"oldValue": {
+			"name": "projects/my-project/databases/(default)/documents/users/u1",
+			"fields": {
+				"name": {"stringValue": "Jane"},
+				"age": {"integerValue": "41"}
+			},
+			"createTime": "2018-03-20T10:00:00.000000Z",
+			"updateTime": "2018-03-20T10:00:00.000000Z"
+		},
+		"value": {
+			"name": "projects/my-project/databases/(default)/documents/users/u1",
+			"fields": {
+				"name": {"stringValue": "Jane"},
+				"age": {"integerValue": "42"},
+				"score": {"doubleValue": 97.5},
+				"ratio": {"doubleValue": "NaN"},
+				"active": {"booleanValue": true},
+				"nickname": {"nullValue": null},
+				"joined": {"timestampValue": "2018-03-20T10:00:00.5Z"},
+				"avatar": {"bytesValue": "iVBORw0K"},
+				"manager": {"referenceValue": "projects/my-project/databases/(default)/documents/users/u2"},
+				"home": {"geoPointValue": {"latitude": 48.8584, "longitude": 2.2945}},
+				"tags": {"arrayValue": {"values": [{"stringValue": "a"}, {"stringValue": "b"}]}},
+				"empty": {"arrayValue": {}},
+				"address": {"mapValue": {"fields": {
+					"city": {"stringValue": "Paris"},
+					"zip": {"integerValue": "75007"}
+				}}}
+			},
+			"createTime": "2018-03-20T10:00:00.000000Z",
+			"updateTime": "2018-03-22T18:20:11.123456Z"
+		},
+		"updateMask": {"fieldPaths": ["age", "score"]}
+	}
+}`
+
+// firestoreValue parses a value in the encoding of the Firestore API.
+func firestoreValue(t *testing.T, raw string) FirestoreValue {
+	t.Helper()
+
+	var v FirestoreValue
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestFirestoreValueInterface(t *testing.T) {
+	tests := []struct {
+		raw  string
+		kind string
+		want interface{}
+	}{
+		{`{}`, "", nil},
+		{`{"nullValue": null}`, "nullValue", nil},
+		{`{"booleanValue": false}`, "booleanValue", false},
+		{`{"integerValue": "-9223372036854775808"}`, "integerValue", int64(math.MinInt64)},
+		{`{"integerValue": 7}`, "integerValue", int64(7)},
+		{`{"doubleValue": 1.5}`, "doubleValue", 1.5},
+		{`{"doubleValue": 3}`, "doubleValue", float64(3)},
+		{`{"doubleValue": "Infinity"}`, "doubleValue", math.Inf(1)},
+		{`{"doubleValue": "-Infinity"}`, "doubleValue", math.Inf(-1)},
+		{`{"timestampValue": "2018-03-20T10:00:00.123456789Z"}`, "timestampValue", time.Date(2018, 3, 20, 10, 0, 0, 123456789, time.UTC)},
+		{`{"stringValue": ""}`, "stringValue", ""},
+		{`{"bytesValue": "AAH/"}`, "bytesValue", []byte{0, 1, 0xff}},
+		{`{"referenceValue": "projects/p/databases/(default)/documents/c/d"}`, "referenceValue", FirestoreReference("projects/p/databases/(default)/documents/c/d")},
+		{`{"geoPointValue": {"latitude": -33.8568, "longitude": 151.2153}}`, "geoPointValue", LatLng{-33.8568, 151.2153}},
+		{`{"geoPointValue": {}}`, "geoPointValue", LatLng{}},
+		{`{"arrayValue": {"values": [{"integerValue": "1"}, {"nullValue": null}, {"arrayValue": {}}]}}`, "arrayValue", []interface{}{int64(1), nil, []interface{}{}}},
+		{`{"mapValue": {}}`, "mapValue", map[string]interface{}{}},
+		{`{"mapValue": {"fields": {"a": {"mapValue": {"fields": {"b": {"booleanValue": true}}}}}}}`, "mapValue", map[string]interface{}{"a": map[string]interface{}{"b": true}}},
+	}
+	for _, tt := range tests {
+		v := firestoreValue(t, tt.raw)
+		if kind := v.Kind(); kind != tt.kind {
+			t.Errorf("%s: got kind %q, want %q", tt.raw, kind, tt.kind)
+		}
+		got, err := v.Interface()
+		if err != nil {
+			t.Errorf("%s: %v", tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.raw, got, tt.want)
+		}
+	}
+
+	got, err := firestoreValue(t, `{"doubleValue": "NaN"}`).Interface()
+	if f, ok := got.(float64); err != nil || !ok || !math.IsNaN(f) {
+		t.Errorf("got %#v, %v for NaN", got, err)
+	}
+}
+
+func TestFirestoreValueInterfaceErrors(t *testing.T) {
+	for _, raw := range []string{
+		`{"booleanValue": "true"}`,
+		`{"integerValue": "4.2"}`,
+		`{"integerValue": "9223372036854775808"}`,
+		`{"doubleValue": "infinite"}`,
+		`{"timestampValue": "yesterday"}`,
+		`{"stringValue": 1}`,
+		`{"bytesValue": "not base64!"}`,
+		`{"geoPointValue": []}`,
+		`{"arrayValue": {"values": [{"integerValue": "x"}]}}`,
+		`{"mapValue": {"fields": {"a": {"integerValue": "x"}}}}`,
+		`{"unknownValue": 1}`,
+	} {
+		if got, err := firestoreValue(t, raw).Interface(); err == nil {
+			t.Errorf("%s: got %#v, want an error", raw, got)
+		}
+	}
+}
+
+func TestFirestoreEvent(t *testing.T) {
+	e := parseEvent(t, firestoreUpdatePayload)
+	if kind := e.Context.Source().Kind; kind != KindFirestore {
+		t.Errorf("got kind %q, want %q", kind, KindFirestore)
+	}
+
+	fe, err := e.FirestoreEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fe.OldValue.Exists() || !fe.Value.Exists() {
+		t.Error("the documents of an update are reported missing")
+	}
+	if want := []string{"age", "score"}; !reflect.DeepEqual(fe.UpdateMask.FieldPaths, want) {
+		t.Errorf("got update mask %v, want %v", fe.UpdateMask.FieldPaths, want)
+	}
+	if want := time.Date(2018, 3, 22, 18, 20, 11, 123456000, time.UTC); !fe.Value.UpdateTime.Equal(want) {
+		t.Errorf("got update time %v, want %v", fe.Value.UpdateTime, want)
+	}
+
+	data, err := fe.Value.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := data["ratio"].(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("got ratio %#v, want NaN", data["ratio"])
+	}
+	delete(data, "ratio")
+	want := map[string]interface{}{
+		"name":     "Jane",
+		"age":      int64(42),
+		"score":    97.5,
+		"active":   true,
+		"nickname": nil,
+		"joined":   time.Date(2018, 3, 20, 10, 0, 0, 500000000, time.UTC),
+		"avatar":   []byte("\x89PNG\r\n"),
+		"manager":  FirestoreReference("projects/my-project/databases/(default)/documents/users/u2"),
+		"home":     LatLng{48.8584, 2.2945},
+		"tags":     []interface{}{"a", "b"},
+		"empty":    []interface{}{},
+		"address":  map[string]interface{}{"city": "Paris", "zip": int64(75007)},
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("got %#v, want %#v", data, want)
+	}
+}
+
+func TestFirestoreEventCreateAndDelete(t *testing.T) {
+	created := &Event{Data: json.RawMessage(`{
+		"oldValue": {},
+		"value": {"name": "projects/p/databases/(default)/documents/c/d", "fields": {}},
+		"updateMask": {}
+	}`)}
+	fe, err := created.FirestoreEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fe.OldValue.Exists() || !fe.Value.Exists() {
+		t.Errorf("got Exists() = %v, %v for a created document", fe.OldValue.Exists(), fe.Value.Exists())
+	}
+
+	deleted := &Event{Data: json.RawMessage(`{
+		"oldValue": {"name": "projects/p/databases/(default)/documents/c/d", "fields": {"a": {"stringValue": "b"}}},
+		"value": {}
+	}`)}
+	if fe, err = deleted.FirestoreEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if !fe.OldValue.Exists() || fe.Value.Exists() {
+		t.Errorf("got Exists() = %v, %v for a deleted document", fe.OldValue.Exists(), fe.Value.Exists())
+	}
+}
+
+type firestoreAddress struct {
+	City string `firestore:"city"`
+	Zip  uint32 `firestore:"zip"`
+}
+
+type firestoreAudit struct {
+	Joined time.Time `firestore:"joined"`
+}
+
+type firestoreUser struct {
+	firestoreAudit
+
+	Name     string             `firestore:"name"`
+	Age      int8               `firestore:"age"`
+	Score    float32            `firestore:"score"`
+	Ratio    float64            `firestore:"ratio"`
+	Active   *bool              `firestore:"active"`
+	Nickname *string            `firestore:"nickname"`
+	Avatar   []byte             `firestore:"avatar"`
+	Manager  string             `firestore:"manager"`
+	Home     LatLng             `firestore:"home"`
+	Tags     []string           `firestore:"tags"`
+	TagArray [3]string          `firestore:"tags,omitempty"`
+	Empty    []interface{}      `firestore:"empty"`
+	Address  *firestoreAddress  `firestore:"address"`
+	Any      interface{}        `firestore:"address"`
+	Fields   map[string]float64 `firestore:"-"`
+	Missing  string             `firestore:"missing"`
+	private  string
+}
+
+func TestDataTo(t *testing.T) {
+	fe, err := parseEvent(t, firestoreUpdatePayload).FirestoreEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nickname := "previous"
+	u := firestoreUser{Nickname: &nickname, Missing: "kept"}
+	if err := fe.Value.DataTo(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	if !math.IsNaN(u.Ratio) {
+		t.Errorf("got ratio %v, want NaN", u.Ratio)
+	}
+	u.Ratio = 0
+	active := true
+	want := firestoreUser{
+		firestoreAudit: firestoreAudit{Joined: time.Date(2018, 3, 20, 10, 0, 0, 500000000, time.UTC)},
+		Name:           "Jane",
+		Age:            42,
+		Score:          97.5,
+		Active:         &active,
+		Avatar:         []byte("\x89PNG\r\n"),
+		Manager:        "projects/my-project/databases/(default)/documents/users/u2",
+		Home:           LatLng{48.8584, 2.2945},
+		Tags:           []string{"a", "b"},
+		TagArray:       [3]string{"a", "b", ""},
+		Empty:          []interface{}{},
+		Address:        &firestoreAddress{City: "Paris", Zip: 75007},
+		Any:            map[string]interface{}{"city": "Paris", "zip": int64(75007)},
+		Missing:        "kept",
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("got %+v, want %+v", u, want)
+	}
+
+	var m map[string]interface{}
+	if err := fe.OldValue.DataTo(&m); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"name": "Jane", "age": int64(41)}; !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+
+	// Fields without a tag match their name, and maps may have keys of any
+	// string type.
+	type named string
+	var v struct {
+		Name string
+		Ages *map[named]int `firestore:"ages"`
+	}
+	d := &FirestoreDocument{Fields: map[string]FirestoreValue{
+		"Name": firestoreValue(t, `{"stringValue": "Jane"}`),
+		"name": firestoreValue(t, `{"stringValue": "ignored"}`),
+		"ages": firestoreValue(t, `{"mapValue": {"fields": {"jane": {"integerValue": "42"}}}}`),
+	}}
+	if err := d.DataTo(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "Jane" || v.Ages == nil || !reflect.DeepEqual(*v.Ages, map[named]int{"jane": 42}) {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestDataToErrors(t *testing.T) {
+	doc := func(raw string) *FirestoreDocument {
+		var d FirestoreDocument
+		if err := json.Unmarshal([]byte(`{"fields": `+raw+`}`), &d); err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+	var nilUser *firestoreUser
+
+	tests := []struct {
+		name    string
+		doc     *FirestoreDocument
+		dst     interface{}
+		wantErr string
+	}{
+		{"nil", doc(`{}`), nil, "non-nil pointer"},
+		{"nil pointer", doc(`{}`), nilUser, "non-nil pointer"},
+		{"not a pointer", doc(`{}`), firestoreUser{}, "non-nil pointer"},
+		{"not a struct or map", doc(`{}`), new(string), "cannot decode"},
+		{"map without string keys", doc(`{"a": {"integerValue": "1"}}`), new(map[int]int64), "cannot decode"},
+		{"string into integer", doc(`{"age": {"stringValue": "42"}}`), new(firestoreUser), "age: cannot decode string into int8"},
+		{"integer into string", doc(`{"name": {"integerValue": "42"}}`), new(firestoreUser), "name: cannot decode int64 into string"},
+		{"double into integer", doc(`{"age": {"doubleValue": 4.2}}`), new(firestoreUser), "age: cannot decode float64 into int8"},
+		{"overflow", doc(`{"age": {"integerValue": "300"}}`), new(firestoreUser), "age: 300 overflows int8"},
+		{"negative unsigned", doc(`{"address": {"mapValue": {"fields": {"zip": {"integerValue": "-1"}}}}}`), new(firestoreUser), "address: zip: -1 overflows uint32"},
+		{"unsigned overflow", doc(`{"address": {"mapValue": {"fields": {"zip": {"integerValue": "4294967296"}}}}}`), new(firestoreUser), "address: zip: 4294967296 overflows uint32"},
+		{"array element", doc(`{"tags": {"arrayValue": {"values": [{"stringValue": "a"}, {"booleanValue": true}]}}}`), new(firestoreUser), "tags: [1]: cannot decode bool"},
+		{"map element", doc(`{"a": {"stringValue": "x"}}`), new(map[string]int), "a: cannot decode string into int"},
+		{"invalid value", doc(`{"age": {"integerValue": "x"}}`), new(firestoreUser), `field "age"`},
+	}
+	for _, tt := range tests {
+		err := tt.doc.DataTo(tt.dst)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"strings"
+
+	"../nodego"
+)
+
+// TriggerKind is the kind of service that emitted an event.
+type TriggerKind string
+
+// Known trigger kinds.
+const (
+	KindUnknown   TriggerKind = ""
+	KindPubSub    TriggerKind = "pubsub"
+	KindStorage   TriggerKind = "storage"
+	KindFirestore TriggerKind = "firestore"
+	KindDatabase  TriggerKind = "database"
+	KindAuth      TriggerKind = "auth"
+)
+
+// EventSource describes where an event comes from. Fields that don't apply
+// to the kind of trigger are empty.
+type EventSource struct {
+	Kind    TriggerKind
+	Project string
+
+	// Topic is the Pub/Sub topic.
+	Topic string
+
+	// Bucket and Object are the Cloud Storage bucket and object.
+	Bucket string
+	Object string
+
+	// Database is the Firestore database, Instance the Firebase Realtime
+	// Database instance, and Path the document or reference within them.
+	Database string
+	Instance string
+	Path     string
+}
+
+// ParseResource parses the resource of an event, in one of the forms:
+//
+//	projects/{project}/topics/{topic}
+//	projects/_/buckets/{bucket}
+//	projects/_/buckets/{bucket}/objects/{object}
+//	projects/{project}/databases/{database}/documents/{path}
+//	projects/_/instances/{instance}/refs/{path}
+//
+// The placeholder project "_" is returned as an empty project. A bare
+// projects/{project}, the resource of Firebase Authentication and Remote
+// Config events among others, only tells the project, with an unknown kind.
+// Other forms return an EventSource of unknown kind.
+func ParseResource(resource string) EventSource {
+	parts := strings.Split(strings.Trim(resource, "/"), "/")
+	if len(parts) < 2 || parts[0] != "projects" || parts[1] == "" {
+		return EventSource{}
+	}
+
+	s := EventSource{}
+	if parts[1] != "_" {
+		s.Project = parts[1]
+	}
+
+	rest := parts[2:]
+	if len(rest) >= 2 && rest[0] == "locations" {
+		// CloudEvents sources include the location of some services.
+		rest = rest[2:]
+	}
+
+	switch {
+	case len(rest) == 0:
+		// The kind is told by the event type.
+	case len(rest) == 2 && rest[0] == "topics":
+		s.Kind = KindPubSub
+		s.Topic = rest[1]
+	case len(rest) == 2 && rest[0] == "buckets":
+		s.Kind = KindStorage
+		s.Bucket = rest[1]
+	case len(rest) >= 4 && rest[0] == "buckets" && rest[2] == "objects":
+		s.Kind = KindStorage
+		s.Bucket = rest[1]
+		s.Object = strings.Join(rest[3:], "/")
+	case len(rest) >= 3 && rest[0] == "databases" && rest[2] == "documents":
+		s.Kind = KindFirestore
+		s.Database = rest[1]
+		s.Path = strings.Join(rest[3:], "/")
+	case len(rest) >= 3 && rest[0] == "instances" && rest[2] == "refs":
+		s.Kind = KindDatabase
+		s.Instance = rest[1]
+		s.Path = "/" + strings.Join(rest[3:], "/")
+	default:
+		return EventSource{}
+	}
+	return s
+}
+
+// ParseEventType returns the kind of trigger emitting events of the given
+// type, in either the legacy form, e.g.
+// providers/cloud.pubsub/eventTypes/topic.publish, or the current one, e.g.
+// google.pubsub.topic.publish.
+func ParseEventType(eventType string) TriggerKind {
+	provider := eventType
+	if strings.HasPrefix(eventType, "providers/") {
+		provider = strings.SplitN(strings.TrimPrefix(eventType, "providers/"), "/", 2)[0]
+	}
+
+	switch {
+	case strings.Contains(provider, "pubsub"):
+		return KindPubSub
+	case strings.Contains(provider, "storage"):
+		return KindStorage
+	case strings.Contains(provider, "firestore"):
+		return KindFirestore
+	case strings.Contains(provider, "firebase.database"):
+		return KindDatabase
+	case strings.Contains(provider, "firebase.auth"):
+		return KindAuth
+	}
+	return KindUnknown
+}
+
+// pushPathPrefix is the prefix of the request path of Pub/Sub push requests.
+const pushPathPrefix = "/execute/_ah/push-handlers/pubsub/"
+
+// ParseRequestPath parses the path of a request made by a non-HTTP trigger:
+//
+//	/execute/_ah/push-handlers/pubsub/projects/{project}/topics/{topic}
+//
+// Other paths return an EventSource of unknown kind. Note that Cloud Storage
+// triggers are delivered with the same path, with arbitrary values.
+func ParseRequestPath(path string) EventSource {
+	if !strings.HasPrefix(path, pushPathPrefix) {
+		return EventSource{}
+	}
+
+	s := ParseResource(strings.TrimPrefix(path, pushPathPrefix))
+	if s.Kind != KindPubSub {
+		return EventSource{}
+	}
+	return s
+}
+
+// Source returns the source of the event, parsed from its resource and type.
+// The type only tells the kind if the resource doesn't.
+func (c *EventContext) Source() EventSource {
+	s := ParseResource(c.Resource)
+	if s.Kind == KindUnknown {
+		s.Kind = ParseEventType(c.EventType)
+	}
+	return s
+}
+
+// eventSource returns the source of an event received on the given request
+// path. The path only fills in what the resource doesn't tell for Pub/Sub
+// events, and the project defaults to the one of the function.
+func eventSource(c *EventContext, path string) EventSource {
+	s := c.Source()
+
+	if p := ParseRequestPath(path); p.Kind == KindPubSub && (s.Kind == KindPubSub || s.Kind == KindUnknown) {
+		s.Kind = KindPubSub
+		if s.Project == "" {
+			s.Project = p.Project
+		}
+		if s.Topic == "" {
+			s.Topic = p.Topic
+		}
+	}
+
+	if s.Project == "" {
+		s.Project = nodego.CurrentRuntime().Project
+	}
+	return s
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"testing"
+
+	"../nodego"
+)
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		resource string
+		want     EventSource
+	}{
+		// Pub/Sub.
+		{
+			"projects/my-project/topics/my-topic",
+			EventSource{Kind: KindPubSub, Project: "my-project", Topic: "my-topic"},
+		},
+		// Cloud Storage.
+		{
+			"projects/_/buckets/my-bucket",
+			EventSource{Kind: KindStorage, Bucket: "my-bucket"},
+		},
+		{
+			"projects/_/buckets/my-bucket/objects/photo.jpg",
+			EventSource{Kind: KindStorage, Bucket: "my-bucket", Object: "photo.jpg"},
+		},
+		{
+			"projects/_/buckets/my-bucket/objects/a/b/photo.jpg",
+			EventSource{Kind: KindStorage, Bucket: "my-bucket", Object: "a/b/photo.jpg"},
+		},
+		// Firestore.
+		{
+			"projects/my-project/databases/(default)/documents/users/u1",
+			EventSource{Kind: KindFirestore, Project: "my-project", Database: "(default)", Path: "users/u1"},
+		},
+		{
+			"projects/my-project/databases/(default)/documents/users/u1/messages/m1",
+			EventSource{Kind: KindFirestore, Project: "my-project", Database: "(default)", Path: "users/u1/messages/m1"},
+		},
+		// Realtime Database.
+		{
+			"projects/_/instances/my-db/refs/users/u1",
+			EventSource{Kind: KindDatabase, Instance: "my-db", Path: "/users/u1"},
+		},
+		// Firebase Authentication, Remote Config and others: the kind is
+		// told by the event type.
+		{
+			"projects/my-project",
+			EventSource{Project: "my-project"},
+		},
+		{
+			"projects/_",
+			EventSource{},
+		},
+		{
+			"projects/my-project/locations/us-central1",
+			EventSource{Project: "my-project"},
+		},
+		// Sources of CloudEvents, with the service removed and a location.
+		{
+			"projects/my-project/locations/us-central1/topics/my-topic",
+			EventSource{Kind: KindPubSub, Project: "my-project", Topic: "my-topic"},
+		},
+		{
+			"projects/my-project/locations/nam5/databases/(default)/documents/users/u1",
+			EventSource{Kind: KindFirestore, Project: "my-project", Database: "(default)", Path: "users/u1"},
+		},
+		{
+			"/projects/my-project/topics/my-topic/",
+			EventSource{Kind: KindPubSub, Project: "my-project", Topic: "my-topic"},
+		},
+		// Malformed resources.
+		{"", EventSource{}},
+		{"projects", EventSource{}},
+		{"projects/", EventSource{}},
+		{"topics/my-topic", EventSource{}},
+		{"organizations/o/projects/p", EventSource{}},
+		{"projects/my-project/topics", EventSource{}},
+		{"projects/my-project/topics/my-topic/subscriptions/s", EventSource{}},
+		{"projects/_/buckets/my-bucket/objects", EventSource{}},
+		{"projects/my-project/databases/(default)", EventSource{}},
+		{"projects/_/instances/my-db", EventSource{}},
+		{"projects/my-project/unknown/thing", EventSource{}},
+	}
+	for _, tt := range tests {
+		if got := ParseResource(tt.resource); got != tt.want {
+			t.Errorf("ParseResource(%q) = %+v, want %+v", tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	tests := []struct {
+		eventType string
+		want      TriggerKind
+	}{
+		{"providers/cloud.pubsub/eventTypes/topic.publish", KindPubSub},
+		{"google.pubsub.topic.publish", KindPubSub},
+		{"google.cloud.pubsub.topic.v1.messagePublished", KindPubSub},
+		{"providers/cloud.storage/eventTypes/object.change", KindStorage},
+		{"google.storage.object.finalize", KindStorage},
+		{"google.cloud.storage.object.v1.finalized", KindStorage},
+		{"providers/cloud.firestore/eventTypes/document.write", KindFirestore},
+		{"google.cloud.firestore.document.v1.written", KindFirestore},
+		{"providers/google.firebase.database/eventTypes/ref.write", KindDatabase},
+		{"google.firebase.database.ref.v1.written", KindDatabase},
+		{"providers/firebase.auth/eventTypes/user.create", KindAuth},
+		{"google.firebase.auth.user.v1.created", KindAuth},
+		{"", KindUnknown},
+		{"providers/", KindUnknown},
+		{"com.example.something", KindUnknown},
+	}
+	for _, tt := range tests {
+		if got := ParseEventType(tt.eventType); got != tt.want {
+			t.Errorf("ParseEventType(%q) = %q, want %q", tt.eventType, got, tt.want)
+		}
+	}
+}
+
+func TestParseRequestPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want EventSource
+	}{
+		{
+			"/execute/_ah/push-handlers/pubsub/projects/my-project/topics/my-topic",
+			EventSource{Kind: KindPubSub, Project: "my-project", Topic: "my-topic"},
+		},
+		{"/execute/_ah/push-handlers/pubsub/projects/_/buckets/my-bucket", EventSource{}},
+		{"/execute/_ah/push-handlers/pubsub/", EventSource{}},
+		{"/execute", EventSource{}},
+		{"/projects/my-project/topics/my-topic", EventSource{}},
+		{"", EventSource{}},
+	}
+	for _, tt := range tests {
+		if got := ParseRequestPath(tt.path); got != tt.want {
+			t.Errorf("ParseRequestPath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	defer nodego.SetRuntime(nodego.Runtime{Project: "function-project"})()
+
+	const pushPath = "/execute/_ah/push-handlers/pubsub/projects/path-project/topics/path-topic"
+
+	tests := []struct {
+		name    string
+		context EventContext
+		path    string
+		want    EventSource
+	}{
+		{
+			name:    "resource over path",
+			context: EventContext{Resource: "projects/my-project/topics/my-topic"},
+			path:    pushPath,
+			want:    EventSource{Kind: KindPubSub, Project: "my-project", Topic: "my-topic"},
+		},
+		{
+			name:    "path without resource",
+			context: EventContext{EventType: "providers/cloud.pubsub/eventTypes/topic.publish"},
+			path:    pushPath,
+			want:    EventSource{Kind: KindPubSub, Project: "path-project", Topic: "path-topic"},
+		},
+		{
+			name: "path without resource and type",
+			path: pushPath,
+			want: EventSource{Kind: KindPubSub, Project: "path-project", Topic: "path-topic"},
+		},
+		{
+			// Cloud Storage events are delivered on the Pub/Sub path.
+			name:    "path ignored for other kinds",
+			context: EventContext{Resource: "projects/_/buckets/my-bucket/objects/o"},
+			path:    pushPath,
+			want:    EventSource{Kind: KindStorage, Project: "function-project", Bucket: "my-bucket", Object: "o"},
+		},
+		{
+			name:    "kind from type",
+			context: EventContext{EventType: "providers/cloud.storage/eventTypes/object.change"},
+			path:    "/execute",
+			want:    EventSource{Kind: KindStorage, Project: "function-project"},
+		},
+		{
+			name:    "project of the function",
+			context: EventContext{Resource: "projects/_/instances/my-db/refs/users/u1"},
+			path:    "/execute",
+			want:    EventSource{Kind: KindDatabase, Project: "function-project", Instance: "my-db", Path: "/users/u1"},
+		},
+		{
+			name:    "auth from type",
+			context: EventContext{Resource: "projects/my-project", EventType: "providers/firebase.auth/eventTypes/user.create"},
+			path:    "/execute",
+			want:    EventSource{Kind: KindAuth, Project: "my-project"},
+		},
+		{
+			name:    "unknown kind of project",
+			context: EventContext{Resource: "projects/my-project", EventType: "google.firebase.remoteconfig.update"},
+			path:    "/execute",
+			want:    EventSource{Project: "my-project"},
+		},
+		{
+			name: "unknown",
+			path: "/execute",
+			want: EventSource{Project: "function-project"},
+		},
+	}
+	for _, tt := range tests {
+		if got := eventSource(&tt.context, tt.path); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StorageEventKind is the kind of change to a Cloud Storage object that fired
+// an event.
+type StorageEventKind string
+
+// Kinds of Cloud Storage events.
+const (
+	StorageUnknown        StorageEventKind = ""
+	StorageFinalize       StorageEventKind = "finalize"
+	StorageDelete         StorageEventKind = "delete"
+	StorageArchive        StorageEventKind = "archive"
+	StorageMetadataUpdate StorageEventKind = "metadataUpdate"
+)
+
+// legacyStorageEventType is the type of all the events of legacy Cloud
+// Storage triggers.
+const legacyStorageEventType = "providers/cloud.storage/eventTypes/object.change"
+
+// ParseStorageEventKind returns the kind of Cloud Storage events of the given
+// type, e.g. google.storage.object.finalize or, for CloudEvents,
+// google.cloud.storage.object.v1.finalized. Legacy object.change events and
+// unknown types return StorageUnknown; Event.StorageObject tells the kind of
+// legacy events from the object.
+func ParseStorageEventKind(eventType string) StorageEventKind {
+	var name string
+	switch {
+	case strings.HasPrefix(eventType, "google.storage.object."):
+		name = strings.TrimPrefix(eventType, "google.storage.object.")
+	case strings.HasPrefix(eventType, "google.cloud.storage.object.v1."):
+		name = strings.TrimPrefix(eventType, "google.cloud.storage.object.v1.")
+	default:
+		return StorageUnknown
+	}
+
+	switch name {
+	case "finalize", "finalized":
+		return StorageFinalize
+	case "delete", "deleted":
+		return StorageDelete
+	case "archive", "archived":
+		return StorageArchive
+	case "metadataUpdate", "metadataUpdated":
+		return StorageMetadataUpdate
+	}
+	return StorageUnknown
+}
+
+// legacyStorageEventKind tells the kind of a legacy object.change event: the
+// object is gone after deletions and archivals, which can't be told apart, and
+// its first metageneration is created by finalizations.
+func legacyStorageEventKind(resourceState string, metageneration int64) StorageEventKind {
+	switch {
+	case resourceState == "not_exists":
+		return StorageDelete
+	case metageneration == 1:
+		return StorageFinalize
+	}
+	return StorageMetadataUpdate
+}
+
+// parseStorageTime parses an RFC 3339 timestamp of an object, which may be
+// empty.
+func parseStorageTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return t, nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Payloads of Cloud Storage triggers: legacy object.change events, events of
+// a single kind and CloudEvents in structured mode.
+const (
+	storageLegacyFinalizePayload = `{
+		"eventId": "62f6bc8a0c1ef0cf",
+		"timestamp": "2018-03-22T18:30:00.042Z",
+		"eventType": "providers/cloud.storage/eventTypes/object.change",
+		"resource": "projects/_/buckets/my-bucket/objects/photo.jpg#1521743399984221",
+		"data": {
+			"kind": "storage#object",
+			"resourceState": "exists",
+			"bucket": "my-bucket",
+			"name": "photo.jpg",
+			"generation": "1521743399984221",
+			"metageneration": "1",
+			"contentType": "image/jpeg",
+			"timeCreated": "2018-03-22T18:29:59.984Z",
+			"updated": "2018-03-22T18:29:59.984Z",
+			"size": "48213"
+		}
+	}`
+
+	storageLegacyMetadataUpdatePayload = `{
+		"eventId": "62f6bc8a0c1ef0d0",
+		"timestamp": "2018-03-22T18:35:00.101Z",
+		"eventType": "providers/cloud.storage/eventTypes/object.change",
+		"resource": "projects/_/buckets/my-bucket/objects/photo.jpg#1521743399984221",
+		"data": {
+			"kind": "storage#object",
+			"resourceState": "exists",
+			"bucket": "my-bucket",
+			"name": "photo.jpg",
+			"generation": "1521743399984221",
+			"metageneration": "3",
+			"timeCreated": "2018-03-22T18:29:59.984Z",
+			"updated": "2018-03-22T18:35:00.087Z",
+			"size": "48213"
+		}
+	}`
+
+	storageLegacyDeletePayload = `{
+		"eventId": "62f6bc8a0c1ef0d1",
+		"timestamp": "2018-03-22T18:40:00.500Z",
+		"eventType": "providers/cloud.storage/eventTypes/object.change",
+		"resource": "projects/_/buckets/my-bucket/objects/photo.jpg#1521743399984221",
+		"data": {
+			"kind": "storage#object",
+			"resourceState": "not_exists",
+			"bucket": "my-bucket",
+			"name": "photo.jpg",
+			"generation": "1521743399984221",
+			"metageneration": "1",
+			"timeCreated": "2018-03-22T18:29:59.984Z",
+			"updated": "2018-03-22T18:29:59.984Z",
+			"size": "48213"
+		}
+	}`
+
+	storageArchivePayload = `{
+		"eventId": "62f6bc8a0c1ef0d2",
+		"timestamp": "2018-03-22T18:45:00.000Z",
+		"eventType": "google.storage.object.archive",
+		"resource": "projects/_/buckets/my-bucket/objects/photo.jpg",
+		"data": {
+			"bucket": "my-bucket",
+			"name": "photo.jpg",
+			"metageneration": "1",
+			"timeCreated": "2018-03-22T18:29:59.984221Z",
+			"updated": "2018-03-22T20:45:00+02:00",
+			"size": "48213"
+		}
+	}`
+
+	storageCloudEventPayload = `{
+		"specversion": "1.0",
+		"id": "62f6bc8a0c1ef0d3",
+		"source": "//storage.googleapis.com/projects/_/buckets/my-bucket",
+		"subject": "objects/photo.jpg",
+		"type": "google.cloud.storage.object.v1.finalized",
+		"time": "2018-03-22T18:50:00.000Z",
+		"datacontenttype": "application/json",
+		"data": {
+			"bucket": "my-bucket",
+			"name": "photo.jpg",
+			"metageneration": "2",
+			"timeCreated": "2018-03-22T18:50:00Z",
+			"updated": "2018-03-22T18:50:00Z",
+			"size": "0"
+		}
+	}`
+)
+
+func TestParseStorageEventKind(t *testing.T) {
+	tests := []struct {
+		eventType string
+		want      StorageEventKind
+	}{
+		{"google.storage.object.finalize", StorageFinalize},
+		{"google.storage.object.delete", StorageDelete},
+		{"google.storage.object.archive", StorageArchive},
+		{"google.storage.object.metadataUpdate", StorageMetadataUpdate},
+		{"google.cloud.storage.object.v1.finalized", StorageFinalize},
+		{"google.cloud.storage.object.v1.deleted", StorageDelete},
+		{"google.cloud.storage.object.v1.archived", StorageArchive},
+		{"google.cloud.storage.object.v1.metadataUpdated", StorageMetadataUpdate},
+		// Both spellings are accepted in both forms.
+		{"google.storage.object.finalized", StorageFinalize},
+		{"google.cloud.storage.object.v1.finalize", StorageFinalize},
+		// The kind of legacy events is told by the object.
+		{legacyStorageEventType, StorageUnknown},
+		{"google.storage.object.", StorageUnknown},
+		{"google.storage.object.copy", StorageUnknown},
+		{"google.cloud.storage.object.v2.finalized", StorageUnknown},
+		{"google.pubsub.topic.publish", StorageUnknown},
+		{"", StorageUnknown},
+	}
+	for _, tt := range tests {
+		if got := ParseStorageEventKind(tt.eventType); got != tt.want {
+			t.Errorf("ParseStorageEventKind(%q) = %q, want %q", tt.eventType, got, tt.want)
+		}
+	}
+}
+
+func TestLegacyStorageEventKind(t *testing.T) {
+	tests := []struct {
+		resourceState  string
+		metageneration int64
+		want           StorageEventKind
+	}{
+		{"exists", 1, StorageFinalize},
+		{"exists", 2, StorageMetadataUpdate},
+		{"", 1, StorageFinalize},
+		{"", 0, StorageMetadataUpdate},
+		// Deletions keep the metageneration of the object.
+		{"not_exists", 1, StorageDelete},
+		{"not_exists", 5, StorageDelete},
+	}
+	for _, tt := range tests {
+		if got := legacyStorageEventKind(tt.resourceState, tt.metageneration); got != tt.want {
+			t.Errorf("legacyStorageEventKind(%q, %d) = %q, want %q", tt.resourceState, tt.metageneration, got, tt.want)
+		}
+	}
+}
+
+func TestStorageObject(t *testing.T) {
+	created := time.Date(2018, 3, 22, 18, 29, 59, 984000000, time.UTC)
+
+	tests := []struct {
+		name        string
+		payload     string
+		kind        StorageEventKind
+		timeCreated time.Time
+		updated     time.Time
+		size        int64
+	}{
+		{"legacy finalize", storageLegacyFinalizePayload, StorageFinalize, created, created, 48213},
+		{"legacy metadata update", storageLegacyMetadataUpdatePayload, StorageMetadataUpdate, created, time.Date(2018, 3, 22, 18, 35, 0, 87000000, time.UTC), 48213},
+		{"legacy delete", storageLegacyDeletePayload, StorageDelete, created, created, 48213},
+		{"archive", storageArchivePayload, StorageArchive, time.Date(2018, 3, 22, 18, 29, 59, 984221000, time.UTC), time.Date(2018, 3, 22, 18, 45, 0, 0, time.UTC), 48213},
+		{"CloudEvent", storageCloudEventPayload, StorageFinalize, time.Date(2018, 3, 22, 18, 50, 0, 0, time.UTC), time.Date(2018, 3, 22, 18, 50, 0, 0, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/execute", strings.NewReader(tt.payload))
+		e, err := ParseRequest(r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if e.Source.Kind != KindStorage || e.Source.Bucket != "my-bucket" {
+			t.Errorf("%s: got source %+v", tt.name, e.Source)
+		}
+
+		so, err := e.StorageObject()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if so.Kind != tt.kind {
+			t.Errorf("%s: got kind %q, want %q", tt.name, so.Kind, tt.kind)
+		}
+		if so.Bucket != "my-bucket" || so.Name != "photo.jpg" {
+			t.Errorf("%s: got object %s/%s", tt.name, so.Bucket, so.Name)
+		}
+		if !so.TimeCreated.Equal(tt.timeCreated) {
+			t.Errorf("%s: got TimeCreated %v, want %v", tt.name, so.TimeCreated, tt.timeCreated)
+		}
+		if !so.Updated.Equal(tt.updated) {
+			t.Errorf("%s: got Updated %v, want %v", tt.name, so.Updated, tt.updated)
+		}
+		if so.Size != tt.size {
+			t.Errorf("%s: got size %d, want %d", tt.name, so.Size, tt.size)
+		}
+	}
+}
+
+func TestStorageObjectTimes(t *testing.T) {
+	tests := []struct {
+		data    string
+		created time.Time
+		wantErr string
+	}{
+		{`{}`, time.Time{}, ""},
+		{`{"timeCreated": ""}`, time.Time{}, ""},
+		{`{"timeCreated": "2018-03-22T18:29:59Z"}`, time.Date(2018, 3, 22, 18, 29, 59, 0, time.UTC), ""},
+		{`{"timeCreated": "2018-03-22T18:29:59.123456789-07:00"}`, time.Date(2018, 3, 23, 1, 29, 59, 123456789, time.UTC), ""},
+		{`{"timeCreated": "2018-03-22 18:29:59"}`, time.Time{}, `invalid timeCreated "2018-03-22 18:29:59"`},
+		{`{"timeCreated": "2018-03-22T18:29:59Z", "updated": "1521743399"}`, time.Time{}, `invalid updated "1521743399"`},
+	}
+	for _, tt := range tests {
+		e := &Event{
+			Context: EventContext{EventType: "google.storage.object.finalize"},
+			Data:    json.RawMessage(tt.data),
+		}
+		so, err := e.StorageObject()
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: got error %v, want %q", tt.data, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+			continue
+		}
+		if !so.TimeCreated.Equal(tt.created) {
+			t.Errorf("%s: got TimeCreated %v, want %v", tt.data, so.TimeCreated, tt.created)
+		}
+	}
+}
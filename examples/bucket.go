@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 
@@ -25,13 +26,8 @@ import (
 func main() {
 	flag.Parse()
 
-	http.HandleFunc(nodego.BucketTrigger, events.Handler(func(event *events.Event) error {
-		obj, err := event.StorageObject()
-		if err != nil {
-			return err
-		}
-
-		nodego.InfoLogger.Printf("%s was last updated at %s", obj.Name, obj.Updated)
+	http.HandleFunc(nodego.BucketTrigger, events.StorageHandler(func(ctx context.Context, obj *events.StorageObject) error {
+		nodego.InfoLoggerFromContext(ctx).Printf("%s was last updated at %s", obj.Name, obj.Updated)
 
 		return nil
 	}))
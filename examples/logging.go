@@ -31,6 +31,10 @@ func main() {
 
 	http.HandleFunc(nodego.HTTPTrigger, nodego.WithLoggerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Println("This is a log message from Go!")
+
+		// Loggers obtained from the request context are attributed to the
+		// right execution even when requests are served concurrently.
+		nodego.InfoLoggerFromContext(r.Context()).Println("This one is too!")
 	}))
 
 	nodego.TakeOver()
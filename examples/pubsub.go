@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 
@@ -25,13 +26,8 @@ import (
 func main() {
 	flag.Parse()
 
-	http.HandleFunc(nodego.PubSubTrigger, events.Handler(func(event *events.Event) error {
-		msg, err := event.PubSubMessage()
-		if err != nil {
-			return err
-		}
-
-		nodego.InfoLogger.Printf("Your message: %s", msg.Data)
+	http.HandleFunc(nodego.PubSubTrigger, events.PubSubHandler(func(ctx context.Context, msg *events.PubSubMessage) error {
+		nodego.InfoLoggerFromContext(ctx).Printf("Your message: %s", msg.Data)
 
 		return nil
 	}))
@@ -17,6 +17,7 @@
 #include <fcntl.h>
 #include <time.h>
 #include <node.h>
+#include <set>
 #include <sstream>
 #include <stdio.h>
 #include <stdlib.h>
@@ -71,6 +72,38 @@ bool write_response(int fd) {
 	}
 }
 
+// handed_over_fds returns the FDs listed with a file in EXECER_REQUESTS by
+// index.js as fd=file pairs. The replacement binary replays the requests saved in the
+// files to serve these connections.
+std::set<int> handed_over_fds() {
+	std::set<int> fds;
+	const char *requests = getenv("EXECER_REQUESTS");
+	if (requests == NULL) {
+		return fds;
+	}
+
+	std::istringstream list(requests);
+	std::string pair;
+	while (std::getline(list, pair, ',')) {
+		size_t eq = pair.find('=');
+		if (eq != std::string::npos && eq + 1 < pair.length()) {
+			fds.insert(atoi(pair.c_str()));
+		}
+	}
+	return fds;
+}
+
+// list_flag formats a flag listing the FDs as a comma separated list.
+std::string list_flag(const char *name, const std::vector<std::string> &fds) {
+	std::ostringstream flag;
+	flag << "-" << name << "=";
+	for (size_t i = 0; i < fds.size(); ++i) {
+		if (i > 0) flag << ",";
+		flag << fds[i];
+	}
+	return flag.str();
+}
+
 void init(Handle<Object> target) {
 	const char bin[] = "./main";
 
@@ -96,7 +129,15 @@ void init(Handle<Object> target) {
 		exit(1);
 	}
 
+	// When EXECER_NO_PROBE is set, sockets are not probed by writing to them.
+	// All of them are preserved and the replacement binary tells listening
+	// sockets from connections by itself.
+	const bool probe = getenv("EXECER_NO_PROBE") == NULL;
+
+	const std::set<int> handed_over = handed_over_fds();
+
 	std::vector<std::string> fds;
+	std::vector<std::string> conns;
 
 	for (struct dirent *ent = readdir(dir); ent != NULL; ent = readdir(dir)) {
 		int fd = atoi(ent->d_name);
@@ -107,6 +148,19 @@ void init(Handle<Object> target) {
 			continue;
 		}
 
+		if (!probe || handed_over.count(fd) > 0) {
+			// Clear CLOEXEC. We need to preserve all sockets, or the
+			// connections whose request is replayed by the replacement binary.
+			if (fcntl(fd, F_SETFD, 0) == -1) {
+				fprintf(stderr, "fcntl(%d, F_SETFD, 0) %d\n", fd, errno);
+				exit(1);
+			}
+			if (probe) {
+				conns.push_back(ent->d_name);
+			}
+			continue;
+		}
+
 		// Save a copy of the FD flags before changing them.
 		int saved_flags = fcntl(fd, F_GETFL);
 		if (saved_flags == -1) {
@@ -120,6 +174,8 @@ void init(Handle<Object> target) {
 			exit(1);
 		}
 
+		// Node has already read the request of the other connections it
+		// accepted, so they are answered here instead of being handed over.
 		if (write_response(fd)) {
 			// Socket was writable, so it isn't listening.
 			continue;
@@ -140,19 +196,15 @@ void init(Handle<Object> target) {
 		fds.push_back(ent->d_name);
 	}
   
-	// Convert the vector to a comma separated list.
-	std::ostringstream flag;
-	flag << "-fds=";
-	for(size_t i = 0; i < fds.size(); ++i) {
-		if(i > 0) flag << ",";
-		flag << fds[i];
-	}
-
 	std::vector<const char*> args;
 	args.push_back(bin);
 
-	std::string str = flag.str();
-	args.push_back(str.c_str());
+	std::string fds_flag = list_flag("fds", fds);
+	std::string conns_flag = list_flag("conns", conns);
+	if (probe) {
+		args.push_back(fds_flag.c_str());
+		args.push_back(conns_flag.c_str());
+	}
 
 	args.push_back(NULL);
 
@@ -12,4 +12,75 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+
+// serializeRequest returns the bytes node read for req, rebuilt from its
+// request line, headers and buffered body, or null if they can't be rebuilt.
+function serializeRequest(req) {
+	const chunked = /\bchunked\b/i.test(req.headers['transfer-encoding'] || '');
+	const hasBody = chunked || Number(req.headers['content-length']) > 0;
+	// Node may have read the rest of the body from the connection without
+	// parsing it yet, so only complete bodies can be handed over.
+	if (hasBody && !req.complete) {
+		return null;
+	}
+	// The function already consumed some of the body.
+	if (req.readableDidRead || req._consuming) {
+		return null;
+	}
+
+	const body = [];
+	for (let chunk = req.read(); chunk !== null; chunk = req.read()) {
+		body.push(typeof chunk === 'string' ? Buffer.from(chunk) : chunk);
+	}
+	const bodyLength = body.reduce(function(n, chunk) { return n + chunk.length; }, 0);
+
+	let head = req.method + ' ' + req.url + ' HTTP/' + req.httpVersion + '\r\n';
+	for (let i = 0; i < req.rawHeaders.length; i += 2) {
+		// The body is replayed decoded.
+		if (hasBody && /^(transfer-encoding|content-length|trailer)$/i.test(req.rawHeaders[i])) {
+			continue;
+		}
+		head += req.rawHeaders[i] + ': ' + req.rawHeaders[i + 1] + '\r\n';
+	}
+	if (hasBody) {
+		head += 'Content-Length: ' + bodyLength + '\r\n';
+	}
+	head += '\r\n';
+
+	return Buffer.concat([Buffer.from(head, 'latin1')].concat(body));
+}
+
+// handOverRequests saves the requests node read from the connections it
+// accepted, such as the one loading the user function, so that the
+// replacement binary serves them instead of node. Each request is written to
+// a file, and EXECER_REQUESTS lists them as fd=file pairs. Connections whose
+// request can't be saved are listed without a file: the native module answers
+// them, or the replacement binary closes them if they are not probed.
+function handOverRequests() {
+	const requests = [];
+	process._getActiveHandles().forEach(function(socket) {
+		const res = socket._httpMessage;
+		const fd = socket._handle && socket._handle.fd;
+		if (!res || res.headersSent || typeof fd !== 'number' || fd < 0) {
+			return;
+		}
+		const req = res.req || (socket.parser && socket.parser.incoming);
+		const data = req && serializeRequest(req);
+		if (!data) {
+			// Listed without a file: its request is lost.
+			requests.push(fd + '=');
+			return;
+		}
+
+		const file = path.join(os.tmpdir(), 'execer-' + process.pid + '-' + fd);
+		fs.writeFileSync(file, data, {mode: 0o600});
+		requests.push(fd + '=' + file);
+	});
+	process.env.EXECER_REQUESTS = requests.join(',');
+}
+
+handOverRequests();
 module.exports = require('./build/Release/execer');
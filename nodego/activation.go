@@ -0,0 +1,142 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first descriptor passed with the socket activation
+// protocol.
+const listenFDsStart = 3
+
+var (
+	socketHandlersMutex sync.RWMutex
+	socketHandlers      = map[string]http.Handler{}
+)
+
+// HandleSocket registers the handler for the sockets with the given name in
+// LISTEN_FDNAMES, when the binary is started with socket activation. Sockets
+// without a registered handler are served by the handler passed to
+// TakeOverWith, http.DefaultServeMux for TakeOver.
+func HandleSocket(name string, handler http.Handler) {
+	socketHandlersMutex.Lock()
+	socketHandlers[name] = handler
+	socketHandlersMutex.Unlock()
+}
+
+// socketHandler returns the handler registered for name, or nil.
+func socketHandler(name string) http.Handler {
+	socketHandlersMutex.RLock()
+	defer socketHandlersMutex.RUnlock()
+	return socketHandlers[name]
+}
+
+// activatedEndpoints returns the sockets passed with the systemd socket
+// activation protocol, i.e. the LISTEN_FDS, LISTEN_PID and LISTEN_FDNAMES
+// environment variables, served by the handler registered for their name or
+// the default handler. The variables are unset so that child processes don't
+// inherit them. ok is false if the protocol is not in use.
+func activatedEndpoints(handler http.Handler, o *options) (endpoints []endpoint, ok bool) {
+	n, names, err := listenFDs()
+	if err != nil {
+		log.Println("Ignoring socket activation:", err)
+		return nil, false
+	}
+	if n == 0 {
+		return nil, false
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+
+		l, err := activatedListener(fd, name)
+		if err != nil {
+			log.Printf("Error taking over socket %q (fd %d): %v", name, fd, err)
+			continue
+		}
+
+		h := handler
+		if sh := o.socketHandler(name); sh != nil {
+			h = withFetcherOrigin(withRequestTimeout(WithExecutionStatus(sh), o), o)
+		}
+		endpoints = append(endpoints, endpoint{l: l, handler: h})
+	}
+	return endpoints, true
+}
+
+// listenFDs parses the socket activation environment variables, returning the
+// number of sockets passed to this process and their names.
+func listenFDs() (int, []string, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return 0, nil, nil
+	}
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid LISTEN_PID %q", pid)
+		}
+		if p != os.Getpid() {
+			// The sockets were meant for another process.
+			return 0, nil, nil
+		}
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	var names []string
+	if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+	return n, names, nil
+}
+
+// activatedListener takes over a socket passed with socket activation.
+// Sockets that are connections, as passed by systemd for Accept=yes units,
+// are served as a single connection.
+func activatedListener(fd int, name string) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+
+	if s, err := InspectFD(fd); err == nil && s.Kind == SocketConn {
+		c, err := net.FileConn(f)
+		if err != nil {
+			return nil, err
+		}
+		return newConnListener(c), nil
+	}
+
+	return net.FileListener(f)
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import "context"
+
+type contextKey int
+
+const (
+	executionIDKey contextKey = iota
+	fetcherOriginKey
+)
+
+// WithExecutionID returns a copy of ctx carrying the given function execution
+// ID.
+func WithExecutionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, executionIDKey, id)
+}
+
+// ExecutionID returns the function execution ID carried by ctx, or an empty
+// string if there is none.
+func ExecutionID(ctx context.Context) string {
+	id, _ := ctx.Value(executionIDKey).(string)
+	return id
+}
@@ -15,21 +15,17 @@
 package nodego
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"time"
 )
 
-// Variables copied from worker.js.
+// Variables copied from worker.js. The variables describing the function
+// itself are read by LoadRuntime.
 var (
-	codeLocationDir        = os.Getenv("CODE_LOCATION")
-	packageJSONFile        = codeLocationDir + "/package.json"
-	entryPoint             = os.Getenv("ENTRY_POINT")
 	supervisorHostname     = os.Getenv("SUPERVISOR_HOSTNAME")
 	supervisorInternalPort = os.Getenv("SUPERVISOR_INTERNAL_PORT")
-	functionTriggerType    = os.Getenv("FUNCTION_TRIGGER_TYPE")
-	functionName           = os.Getenv("FUNCTION_NAME")
-	functionTimeoutSec, _  = strconv.ParseInt(os.Getenv("FUNCTION_TIMEOUT_SEC"), 10, 64)
 )
 
 // Constants copied from worker.js.
@@ -45,10 +41,101 @@ const (
 )
 
 var (
-	supervisorLogTimeout = time.Duration(max(60, functionTimeoutSec)) * time.Second
+	supervisorLogTimeout = maxDuration(defaultFunctionTimeout, runtimeInfo.Timeout)
+
+	// logFlushTimeout bounds how long a response waits for the logs of its
+	// execution to be delivered.
+	logFlushTimeout = 10 * time.Second
+
+	// minLogSeverity is the lowest severity that is logged, read from the
+	// NODEGO_LOG_SEVERITY environment variable.
+	minLogSeverity = logSeverityFromEnv()
+
+	// Retry policy for calls to the supervisor.
+	supervisorMaxRetries     = intFromEnv("NODEGO_SUPERVISOR_MAX_RETRIES", 5)
+	supervisorRetryBaseDelay = durationFromEnv("NODEGO_SUPERVISOR_RETRY_BASE_DELAY", 100*time.Millisecond)
+	supervisorRetryMaxDelay  = durationFromEnv("NODEGO_SUPERVISOR_RETRY_MAX_DELAY", 10*time.Second)
+
+	// maxBufferedLogEntries bounds the number of entries waiting to be sent
+	// to the supervisor, and logOverflowPolicy tells what happens beyond it.
+	maxBufferedLogEntries = intFromEnv("NODEGO_LOG_BUFFER_ENTRIES", 5*maxLogBatchEntries)
+	logOverflowPolicy     = overflowPolicyFromEnv()
 )
 
-func max(a, b int64) int64 {
+// overflowPolicyFromEnv parses NODEGO_LOG_OVERFLOW, defaulting to dropping the
+// oldest entries so that a slow supervisor never stalls the handlers.
+func overflowPolicyFromEnv() OverflowPolicy {
+	name := os.Getenv("NODEGO_LOG_OVERFLOW")
+	if name == "" {
+		return OverflowDropOldest
+	}
+
+	p, err := ParseOverflowPolicy(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ignoring NODEGO_LOG_OVERFLOW:", err)
+		return OverflowDropOldest
+	}
+	return p
+}
+
+// intFromEnv parses a non-negative integer from the named environment
+// variable, returning def if it is not set or invalid.
+func intFromEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		fmt.Fprintf(os.Stderr, "Ignoring %s: invalid value %q\n", name, v)
+		return def
+	}
+	return n
+}
+
+// durationFromEnv parses a non-negative duration such as "250ms" from the
+// named environment variable, returning def if it is not set or invalid.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		fmt.Fprintf(os.Stderr, "Ignoring %s: invalid value %q\n", name, v)
+		return def
+	}
+	return d
+}
+
+// logSeverityFromEnv parses NODEGO_LOG_SEVERITY, defaulting to logging
+// everything.
+func logSeverityFromEnv() Severity {
+	name := os.Getenv("NODEGO_LOG_SEVERITY")
+	if name == "" {
+		return Debug
+	}
+
+	s, err := ParseSeverity(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ignoring NODEGO_LOG_SEVERITY:", err)
+		return Debug
+	}
+	return s
+}
+
+// functionTimeout returns the timeout of the current runtime. It is also how
+// long TakeOver waits for in-flight requests to complete when shutting down.
+func functionTimeout() time.Duration {
+	if d := CurrentRuntime().Timeout; d > 0 {
+		return d
+	}
+	return defaultFunctionTimeout
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
 	if a > b {
 		return a
 	}
@@ -0,0 +1,78 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import "net"
+
+// SocketKind is the kind of an inherited socket.
+type SocketKind int
+
+// Kinds of inherited sockets.
+const (
+	// SocketTCPListener is a listening TCP socket.
+	SocketTCPListener SocketKind = iota + 1
+	// SocketUnixListener is a listening Unix domain socket.
+	SocketUnixListener
+	// SocketConn is a connected stream socket, e.g. an accepted connection.
+	SocketConn
+)
+
+func (k SocketKind) String() string {
+	switch k {
+	case SocketTCPListener:
+		return "tcp listener"
+	case SocketUnixListener:
+		return "unix listener"
+	case SocketConn:
+		return "connection"
+	}
+	return "unknown"
+}
+
+// InheritedSocket is a stream socket descriptor inherited from the process
+// that started this binary.
+type InheritedSocket struct {
+	FD   int
+	Kind SocketKind
+	// LocalAddr is the address the socket is bound to, if any.
+	LocalAddr net.Addr
+}
+
+// IsListener reports whether the socket is listening for connections.
+func (s InheritedSocket) IsListener() bool {
+	return s.Kind == SocketTCPListener || s.Kind == SocketUnixListener
+}
+
+// AcceptedBy reports whether the connection s was accepted by the listening
+// socket l, i.e. whether s is bound to the address l listens on. Sockets of
+// the process's own outbound connections are bound to other addresses.
+func (s InheritedSocket) AcceptedBy(l InheritedSocket) bool {
+	if s.Kind != SocketConn || !l.IsListener() {
+		return false
+	}
+
+	switch la := l.LocalAddr.(type) {
+	case *net.TCPAddr:
+		sa, ok := s.LocalAddr.(*net.TCPAddr)
+		if !ok || sa.Port != la.Port {
+			return false
+		}
+		return la.IP == nil || la.IP.IsUnspecified() || la.IP.Equal(sa.IP)
+	case *net.UnixAddr:
+		sa, ok := s.LocalAddr.(*net.UnixAddr)
+		return ok && la.Name != "" && sa.Name == la.Name
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux,!darwin
+
+package nodego
+
+import "errors"
+
+var errInspectUnsupported = errors.New("inspecting descriptors is not supported on this platform")
+
+// InheritedSockets lists the stream sockets among the open descriptors of the
+// process. It is not supported on this platform.
+func InheritedSockets() ([]InheritedSocket, error) {
+	return nil, errInspectUnsupported
+}
+
+// InspectFD tells what kind of stream socket fd is. It is not supported on
+// this platform.
+func InspectFD(fd int) (InheritedSocket, error) {
+	return InheritedSocket{}, errInspectUnsupported
+}
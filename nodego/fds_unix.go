@@ -0,0 +1,147 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux darwin
+
+package nodego
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// InheritedSockets lists the stream sockets among the open descriptors of the
+// process, other than stdin, stdout and stderr. Connections are only listed if
+// they were accepted by one of the listed listening sockets; others, such as
+// the process's own outbound connections, are skipped.
+//
+// Unlike the execer node module, InheritedSockets does not write to the
+// sockets to tell them apart: it asks the kernel whether they are listening
+// with SO_ACCEPTCONN.
+func InheritedSockets() ([]InheritedSocket, error) {
+	dir := "/proc/self/fd"
+	if runtime.GOOS == "darwin" {
+		dir = "/dev/fd"
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	for _, name := range names {
+		fd, err := strconv.Atoi(name)
+		if err != nil || fd <= 2 {
+			continue
+		}
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+
+	var all, listeners []InheritedSocket
+	for _, fd := range fds {
+		s, err := InspectFD(fd)
+		if err != nil {
+			// Not a stream socket, or already closed like the descriptor
+			// used to read the directory.
+			continue
+		}
+		all = append(all, s)
+		if s.IsListener() {
+			listeners = append(listeners, s)
+		}
+	}
+
+	var sockets []InheritedSocket
+	for _, s := range all {
+		if s.IsListener() || acceptedByAny(s, listeners) {
+			sockets = append(sockets, s)
+		}
+	}
+	return sockets, nil
+}
+
+// acceptedByAny reports whether the connection s was accepted by one of
+// listeners.
+func acceptedByAny(s InheritedSocket, listeners []InheritedSocket) bool {
+	for _, l := range listeners {
+		if s.AcceptedBy(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// InspectFD tells what kind of stream socket fd is. It returns an error if fd
+// is not a stream socket.
+func InspectFD(fd int) (InheritedSocket, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return InheritedSocket{}, err
+	}
+
+	typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return InheritedSocket{}, err
+	}
+	if typ != syscall.SOCK_STREAM {
+		return InheritedSocket{}, errors.New("not a stream socket")
+	}
+
+	listening, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	if err != nil {
+		return InheritedSocket{}, err
+	}
+
+	s := InheritedSocket{FD: fd, LocalAddr: sockaddrToAddr(sa)}
+	switch {
+	case listening == 0:
+		s.Kind = SocketConn
+	case isUnixSockaddr(sa):
+		s.Kind = SocketUnixListener
+	default:
+		s.Kind = SocketTCPListener
+	}
+	return s, nil
+}
+
+// sockaddrToAddr converts the address of a stream socket, or returns nil if
+// it is of an unknown family.
+func sockaddrToAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return nil
+}
+
+func isUnixSockaddr(sa syscall.Sockaddr) bool {
+	_, ok := sa.(*syscall.SockaddrUnix)
+	return ok
+}
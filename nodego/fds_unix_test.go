@@ -0,0 +1,205 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux darwin
+
+package nodego
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// filer is implemented by the listeners and connections of package net.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// inspect inspects a duplicate of the descriptor of v.
+func inspect(t *testing.T, v filer) InheritedSocket {
+	t.Helper()
+
+	f, err := v.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	s, err := InspectFD(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// connect returns both ends of a connection accepted by l.
+func connect(t *testing.T, l net.Listener) (accepted, dialed net.Conn) {
+	t.Helper()
+
+	dialed, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dialed.Close() })
+
+	accepted, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accepted.Close() })
+	return accepted, dialed
+}
+
+func TestInspectFD(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close()
+
+	for _, l := range []net.Listener{tcp, unix} {
+		ls := inspect(t, l.(filer))
+		wantKind := SocketTCPListener
+		if l == unix {
+			wantKind = SocketUnixListener
+		}
+		if ls.Kind != wantKind || !ls.IsListener() {
+			t.Errorf("%s: got kind %v, want %v", l.Addr(), ls.Kind, wantKind)
+		}
+		if ls.LocalAddr.String() != l.Addr().String() {
+			t.Errorf("%s: got local address %v", l.Addr(), ls.LocalAddr)
+		}
+
+		accepted, dialed := connect(t, l)
+		as := inspect(t, accepted.(filer))
+		ds := inspect(t, dialed.(filer))
+		for _, s := range []InheritedSocket{as, ds} {
+			if s.Kind != SocketConn || s.IsListener() {
+				t.Errorf("%s: got kind %v for a connection, want %v", l.Addr(), s.Kind, SocketConn)
+			}
+		}
+
+		if !as.AcceptedBy(ls) {
+			t.Errorf("%s: the accepted connection is not recognized", l.Addr())
+		}
+		if ds.AcceptedBy(ls) {
+			t.Errorf("%s: the dialed connection is taken for an accepted one", l.Addr())
+		}
+		if ls.AcceptedBy(ls) {
+			t.Errorf("%s: the listener is taken for an accepted connection", l.Addr())
+		}
+	}
+}
+
+func TestInspectFDNotSocket(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	if s, err := InspectFD(int(r.Fd())); err == nil {
+		t.Errorf("got %+v for a pipe, want an error", s)
+	}
+}
+
+func TestInheritedSocketsSkipsOutboundConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The client connects to a listener the process does not own.
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, _ := connect(t, l)
+	_, outbound := connect(t, other)
+	other.Close()
+
+	acceptedFD := inspect(t, accepted.(filer)).FD
+	outboundFD := inspect(t, outbound.(filer)).FD
+
+	sockets, err := InheritedSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[int]bool{}
+	for _, s := range sockets {
+		found[s.FD] = true
+	}
+	if !found[acceptedFD] {
+		t.Error("the accepted connection is not listed")
+	}
+	if found[outboundFD] {
+		t.Error("the outbound connection is listed")
+	}
+}
+
+func TestAcceptedBy(t *testing.T) {
+	tcp := func(ip string, port int) InheritedSocket {
+		return InheritedSocket{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+	}
+	listener := func(s InheritedSocket) InheritedSocket {
+		s.Kind = SocketTCPListener
+		return s
+	}
+	conn := func(s InheritedSocket) InheritedSocket {
+		s.Kind = SocketConn
+		return s
+	}
+	unix := func(kind SocketKind, name string) InheritedSocket {
+		return InheritedSocket{Kind: kind, LocalAddr: &net.UnixAddr{Name: name, Net: "unix"}}
+	}
+
+	tests := []struct {
+		name     string
+		conn     InheritedSocket
+		listener InheritedSocket
+		want     bool
+	}{
+		{"same address", conn(tcp("127.0.0.1", 8080)), listener(tcp("127.0.0.1", 8080)), true},
+		{"unspecified IPv4", conn(tcp("10.0.0.2", 8080)), listener(tcp("0.0.0.0", 8080)), true},
+		{"unspecified IPv6", conn(tcp("10.0.0.2", 8080)), listener(tcp("::", 8080)), true},
+		{"IPv6", conn(tcp("::1", 8080)), listener(tcp("::1", 8080)), true},
+		{"IPv4-mapped", conn(tcp("::ffff:127.0.0.1", 8080)), listener(tcp("127.0.0.1", 8080)), true},
+		{"no IP", conn(tcp("10.0.0.2", 8080)), listener(InheritedSocket{LocalAddr: &net.TCPAddr{Port: 8080}}), true},
+		{"other port", conn(tcp("127.0.0.1", 45678)), listener(tcp("0.0.0.0", 8080)), false},
+		{"other IP", conn(tcp("10.0.0.2", 8080)), listener(tcp("127.0.0.1", 8080)), false},
+		{"other IPv6", conn(tcp("fe80::1", 8080)), listener(tcp("::1", 8080)), false},
+		{"not a listener", conn(tcp("127.0.0.1", 8080)), conn(tcp("127.0.0.1", 8080)), false},
+		{"not a connection", listener(tcp("127.0.0.1", 8080)), listener(tcp("127.0.0.1", 8080)), false},
+		{"TCP and Unix", conn(tcp("127.0.0.1", 8080)), unix(SocketUnixListener, "/tmp/s"), false},
+		{"Unix", unix(SocketConn, "/tmp/s"), unix(SocketUnixListener, "/tmp/s"), true},
+		{"other Unix path", unix(SocketConn, "/tmp/t"), unix(SocketUnixListener, "/tmp/s"), false},
+		{"unnamed Unix", unix(SocketConn, ""), unix(SocketUnixListener, ""), false},
+		{"no address", InheritedSocket{Kind: SocketConn}, InheritedSocket{Kind: SocketTCPListener}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.conn.AcceptedBy(tt.listener); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
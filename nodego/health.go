@@ -0,0 +1,228 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports that a part of the function is healthy by returning nil. It
+// should return early when ctx is done.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+var (
+	checksMutex     sync.Mutex
+	initHooks       []namedCheck
+	readinessChecks []namedCheck
+	livenessChecks  []namedCheck
+)
+
+// AddInitHook registers a function run once when TakeOver starts, e.g. to
+// load the configuration or warm up database pools. Hooks run in the order
+// they are registered, and the supervisor's /load request waits for them.
+// Hooks registered after TakeOver is called are ignored.
+func AddInitHook(name string, hook Check) {
+	checksMutex.Lock()
+	initHooks = append(initHooks, namedCheck{name, hook})
+	checksMutex.Unlock()
+}
+
+// AddReadinessCheck registers a check run on every /load request once the
+// init hooks succeeded. The function is reported as not ready if any check
+// fails.
+func AddReadinessCheck(name string, check Check) {
+	checksMutex.Lock()
+	readinessChecks = append(readinessChecks, namedCheck{name, check})
+	checksMutex.Unlock()
+}
+
+// AddLivenessCheck registers a check run on every /check request. The
+// function is reported as unhealthy if any check fails.
+func AddLivenessCheck(name string, check Check) {
+	checksMutex.Lock()
+	livenessChecks = append(livenessChecks, namedCheck{name, check})
+	checksMutex.Unlock()
+}
+
+func registeredChecks(checks *[]namedCheck) []namedCheck {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	return append([]namedCheck(nil), *checks...)
+}
+
+// checkFailure is a failed check in a health report.
+type checkFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// healthReport is the body of a failed /load or /check request.
+type healthReport struct {
+	Status   string         `json:"status"`
+	Failures []checkFailure `json:"failures"`
+}
+
+// runCheck runs a check, giving up after the timeout even if the check
+// ignores its context.
+func runCheck(ctx context.Context, c namedCheck, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errc <- c.check(ctx)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timeout after %s", timeout)
+	}
+}
+
+// runChecks runs checks concurrently and returns the failures.
+func runChecks(ctx context.Context, checks []namedCheck, timeout time.Duration) []checkFailure {
+	var mu sync.Mutex
+	var failures []checkFailure
+
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			if err := runCheck(ctx, c, timeout); err != nil {
+				mu.Lock()
+				failures = append(failures, checkFailure{c.name, err.Error()})
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return failures
+}
+
+// health serves the /load and /check endpoints.
+type health struct {
+	loadTimeout  time.Duration
+	checkTimeout time.Duration
+
+	initDone     chan struct{}
+	initFailures []checkFailure
+}
+
+// startHealth runs the init hooks in the background.
+func startHealth(o *options) *health {
+	h := &health{
+		loadTimeout:  o.loadTimeout,
+		checkTimeout: o.checkTimeout,
+		initDone:     make(chan struct{}),
+	}
+
+	hooks := registeredChecks(&initHooks)
+	go func() {
+		defer close(h.initDone)
+
+		ctx, cancel := context.WithTimeout(context.Background(), h.loadTimeout)
+		defer cancel()
+
+		for _, hook := range hooks {
+			if err := runCheck(ctx, hook, h.loadTimeout); err != nil {
+				ErrorLogger.Printf("Init hook %q failed: %v", hook.name, err)
+				h.initFailures = append(h.initFailures, checkFailure{hook.name, err.Error()})
+				return
+			}
+		}
+	}()
+
+	return h
+}
+
+func (h *health) load(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-h.initDone:
+	case <-time.After(h.loadTimeout):
+		writeHealthFailure(w, "loading", []checkFailure{{"init", "init hooks did not complete in time"}})
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	if len(h.initFailures) > 0 {
+		writeHealthFailure(w, "failed", h.initFailures)
+		return
+	}
+
+	if failures := runChecks(r.Context(), registeredChecks(&readinessChecks), h.checkTimeout); len(failures) > 0 {
+		writeHealthFailure(w, "not ready", failures)
+		return
+	}
+
+	fmt.Fprintln(w, "User function is ready")
+}
+
+func (h *health) check(w http.ResponseWriter, r *http.Request) {
+	if failures := runChecks(r.Context(), registeredChecks(&livenessChecks), h.checkTimeout); len(failures) > 0 {
+		writeHealthFailure(w, "unhealthy", failures)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// writeHealthFailure responds with a JSON health report and a 503 status.
+func writeHealthFailure(w http.ResponseWriter, status string, failures []checkFailure) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(healthReport{
+		Status:   status,
+		Failures: failures,
+	})
+}
+
+// withInternalEndpoints serves the endpoints called by the supervisor and
+// passes other requests to handler.
+func withInternalEndpoints(handler http.Handler, h *health, o *options) http.Handler {
+	return withFetcherOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/load":
+			if o.allowInternal(w, r) {
+				h.load(w, r)
+			}
+		case "/check":
+			if o.allowInternal(w, r) {
+				h.check(w, r)
+			}
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}), o)
+}
@@ -18,6 +18,7 @@
 package nodego
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -26,52 +27,222 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 )
 
-var fds = flag.String("fds", "", "fd1,fd2,...")
+var (
+	fds   = flag.String("fds", "", "fd1,fd2,...")
+	conns = flag.String("conns", "", "fd1,fd2,... of accepted connections, whose requests read by node are listed in "+requestsEnv)
+)
+
+// errNoSockets is returned by TakeOverWith when there is no inherited socket
+// to serve.
+var errNoSockets = errors.New("nodego: no inherited socket to serve")
+
+// startupSockets are the sockets inherited from node, inspected before user
+// code can open descriptors of its own and before the init hooks and the log
+// worker run.
+var (
+	startupSockets    []InheritedSocket
+	startupSocketsErr error
+)
+
+func init() {
+	startupSockets, startupSocketsErr = InheritedSockets()
+}
 
 // TakeOver attempts to take over all of node's sockets that were open when it
 // execve'd this binary. This binary must have been started by the execer node
 // module for this to work.
+//
+// If the fds flag is not set, TakeOver uses the listening sockets found by
+// inspecting the open descriptors when the package was initialized, e.g. when
+// the execer node module runs with EXECER_NO_PROBE set.
+//
+// When started with systemd socket activation (LISTEN_FDS) and without the
+// fds flag, TakeOver serves the passed sockets instead, using the handlers
+// registered with HandleSocket.
+//
+// Connections passed with the conns flag, or found by inspecting the
+// descriptors without the fds flag, are served like any other. The requests
+// node already read from them, such as the one loading the user function, are
+// saved by the execer node module to the files listed in EXECER_REQUESTS and
+// replayed to the handlers first. Connections listed there without a file
+// lost their request and are closed.
+//
+// When the process receives SIGTERM or SIGINT, TakeOver stops accepting new
+// connections, waits up to FUNCTION_TIMEOUT_SEC for in-flight requests to
+// complete, flushes pending logs to the supervisor and exits with one of the
+// ExitShutdown codes.
+//
+// TakeOver serves http.DefaultServeMux. Use TakeOverWith to serve another
+// handler or configure the HTTP servers.
 func TakeOver() {
+	if err := TakeOverWith(http.DefaultServeMux); err != nil {
+		log.Println(err)
+	}
+}
+
+// TakeOverWith is like TakeOver, but serves handler with HTTP servers
+// configured by opts. The /load and /check endpoints called by the supervisor
+// are answered before requests reach handler.
+//
+// /load waits for the hooks registered with AddInitHook and runs the checks
+// registered with AddReadinessCheck, /check runs the checks registered with
+// AddLivenessCheck. Failures are reported with a 503 status and a JSON body.
+// Use WithInternalOrigins to only answer them for the supervisor.
+//
+// The outcome of the requests passed to handler is reported to the supervisor
+// as with WithExecutionStatus. Their context expires after the function
+// timeout, and the stack of the handlers still running then is logged. Use
+// WithTimeoutPolicy(TimeoutRespond) to answer them with a 504 response right
+// away, and WithRequestTimeout to change the timeout.
+//
+// Use WithListener to serve another listener than the inherited sockets, and
+// WithContext to shut down without exiting the process. TakeOverWith returns
+// an error if there is no socket to serve.
+func TakeOverWith(handler http.Handler, opts ...Option) error {
+	o := newOptions(opts)
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	handler = withInternalEndpoints(withRequestTimeout(WithExecutionStatus(handler), o), startHealth(o), o)
+
+	if len(o.listeners) > 0 {
+		return serve(listenerEndpoints(o.listeners, handler), o)
+	}
+
 	if len(*fds) == 0 {
-		fmt.Fprintln(os.Stderr, "Required flag fds was not set.")
+		if endpoints, ok := activatedEndpoints(handler, o); ok {
+			return serve(endpoints, o)
+		}
+	}
+
+	listenFDs, connFDs := inheritedFDs()
+	if len(listenFDs) == 0 {
+		fmt.Fprintln(os.Stderr, "Flag fds was not set and no listening socket was inherited.")
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
-	ready := func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "User function is ready")
+	var listeners []net.Listener
+	for _, fd := range listenFDs {
+		f := os.NewFile(uintptr(fd), "")
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Println("Error creating FileListener:", err)
+			continue
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	requests := handedOverRequests()
+	for _, fd := range connFDs {
+		f := os.NewFile(uintptr(fd), "")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			log.Println("Error creating FileConn:", err)
+			continue
+		}
+
+		if req, ok := requests[fd]; ok {
+			if req == nil {
+				c.Close()
+				continue
+			}
+			c = newReplayConn(c, req)
+		}
+		listeners = append(listeners, newConnListener(c))
+	}
+
+	if len(listeners) == 0 {
+		return errNoSockets
+	}
+	return serve(listenerEndpoints(listeners, handler), o)
+}
+
+// inheritedFDs returns the listening sockets and connections passed with the
+// fds and conns flags. If the fds flag is not set, it returns the sockets
+// found when the package was initialized instead.
+func inheritedFDs() (listenFDs, connFDs []int) {
+	if len(*fds) == 0 {
+		if startupSocketsErr != nil {
+			log.Println("Error inspecting inherited sockets:", startupSocketsErr)
+			return nil, nil
+		}
+
+		for _, s := range startupSockets {
+			if s.IsListener() {
+				listenFDs = append(listenFDs, s.FD)
+			} else {
+				connFDs = append(connFDs, s.FD)
+			}
+		}
+		return listenFDs, connFDs
+	}
+
+	return parseFDs(*fds), parseFDs(*conns)
+}
+
+// parseFDs parses a comma separated list of descriptors.
+func parseFDs(list string) []int {
+	if len(list) == 0 {
+		return nil
 	}
-	http.HandleFunc("/load", ready)
-	http.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "OK")
-	})
 
-	var wg sync.WaitGroup
-	for _, arg := range strings.Split(*fds, ",") {
+	var fds []int
+	for _, arg := range strings.Split(list, ",") {
 		fd, err := strconv.Atoi(arg)
 		if err != nil {
 			log.Printf("Error converting arg %q to int: %v", arg, err)
 			continue
 		}
-		f := os.NewFile(uintptr(fd), "")
-		l, err := net.FileListener(f)
-		f.Close()
+		fds = append(fds, fd)
+	}
+	return fds
+}
+
+// requestsEnv lists the requests node read from the connections it handed
+// over as fd=file pairs. It is set by the execer node module.
+const requestsEnv = "EXECER_REQUESTS"
+
+// handedOverRequests reads the requests listed in EXECER_REQUESTS, then
+// removes their files. Connections listed without a file map to nil.
+func handedOverRequests() map[int][]byte {
+	list := os.Getenv(requestsEnv)
+	os.Unsetenv(requestsEnv)
+	if len(list) == 0 {
+		return nil
+	}
+
+	requests := make(map[int][]byte)
+	for _, pair := range strings.Split(list, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			log.Printf("Error parsing %s entry %q", requestsEnv, pair)
+			continue
+		}
+		fd, err := strconv.Atoi(pair[:i])
 		if err != nil {
-			log.Println("Error creating FileListener:", err)
+			log.Printf("Error converting %s entry %q to int: %v", requestsEnv, pair, err)
 			continue
 		}
 
-		log.Println("Resuming HTTP server on", l.Addr())
-		wg.Add(1)
-		go func() {
-			log.Println(http.Serve(l, nil))
-			l.Close()
-			wg.Done()
-		}()
+		file := pair[i+1:]
+		if len(file) == 0 {
+			requests[fd] = nil
+			continue
+		}
+		req, err := os.ReadFile(file)
+		os.Remove(file)
+		if err != nil {
+			log.Println("Error reading handed over request:", err)
+			requests[fd] = nil
+			continue
+		}
+		requests[fd] = req
 	}
-
-	wg.Wait()
+	return requests
 }
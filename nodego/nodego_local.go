@@ -27,15 +27,57 @@ var address = flag.String("addr", ":8080", "host and port number")
 
 // TakeOver listens and servers http.DefaultServeMux on the address passed by a
 // command line flag.
+//
+// When started with systemd socket activation (LISTEN_FDS), TakeOver serves
+// the passed sockets instead, using the handlers registered with HandleSocket.
+//
+// As with the node version, SIGTERM and SIGINT trigger a graceful shutdown.
+// TakeOver panics if it can't listen on the address.
 func TakeOver() {
+	if err := TakeOverWith(http.DefaultServeMux); err != nil {
+		panic(err)
+	}
+}
+
+// TakeOverWith is like TakeOver, but serves handler with HTTP servers
+// configured by opts. As with the node version, the /load and /check
+// endpoints are answered before requests reach handler.
+//
+// /load waits for the hooks registered with AddInitHook and runs the checks
+// registered with AddReadinessCheck, /check runs the checks registered with
+// AddLivenessCheck. Failures are reported with a 503 status and a JSON body.
+// Use WithInternalOrigins to only answer them for the supervisor.
+//
+// The outcome of the requests passed to handler is reported to the supervisor
+// as with WithExecutionStatus. Their context expires after the function
+// timeout, and the stack of the handlers still running then is logged. Use
+// WithTimeoutPolicy(TimeoutRespond) to answer them with a 504 response right
+// away, and WithRequestTimeout to change the timeout.
+//
+// Use WithListener to serve another listener than the address of the addr
+// flag, and WithContext to shut down without exiting the process. TakeOverWith
+// returns an error if it can't listen on the address.
+func TakeOverWith(handler http.Handler, opts ...Option) error {
+	o := newOptions(opts)
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	handler = withInternalEndpoints(withRequestTimeout(WithExecutionStatus(handler), o), startHealth(o), o)
+
+	if len(o.listeners) > 0 {
+		return serve(listenerEndpoints(o.listeners, handler), o)
+	}
+
+	if endpoints, ok := activatedEndpoints(handler, o); ok {
+		return serve(endpoints, o)
+	}
+
 	lis, err := net.Listen("tcp", *address)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	log.Println("listening on", lis.Addr().String())
 
-	if err := http.Serve(lis, nil); err != nil {
-		panic(err)
-	}
+	return serve(listenerEndpoints([]net.Listener{lis}, handler), o)
 }
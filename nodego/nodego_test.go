@@ -0,0 +1,53 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build node
+
+package nodego
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHandedOverRequests(t *testing.T) {
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "execer-1-12")
+	if err := os.WriteFile(saved, []byte("GET /load HTTP/1.1\r\n\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(requestsEnv, "12="+saved+",13=,14="+filepath.Join(dir, "missing")+",x=y,15")
+	got := handedOverRequests()
+	want := map[int][]byte{
+		12: []byte("GET /load HTTP/1.1\r\n\r\n"),
+		13: nil,
+		14: nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := os.Stat(saved); !os.IsNotExist(err) {
+		t.Errorf("the request file was not removed: %v", err)
+	}
+	if v, ok := os.LookupEnv(requestsEnv); ok {
+		t.Errorf("%s is still set to %q", requestsEnv, v)
+	}
+	if got := handedOverRequests(); got != nil {
+		t.Errorf("got %v without %s", got, requestsEnv)
+	}
+}
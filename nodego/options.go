@@ -0,0 +1,216 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultCheckTimeout is how long a readiness or liveness check may run by
+// default.
+const defaultCheckTimeout = 5 * time.Second
+
+// Option configures TakeOverWith.
+type Option func(*options)
+
+type options struct {
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	errorLog          *log.Logger
+	socketHandlers    map[string]http.Handler
+	loadTimeout       time.Duration
+	checkTimeout      time.Duration
+	requestTimeout    time.Duration
+	timeoutPolicy     TimeoutPolicy
+	timeoutStatus     int
+	restrictInternal  bool
+	internalOrigins   []string
+	listeners         []net.Listener
+	ctx               context.Context
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		errorLog:     ErrorLogger,
+		loadTimeout:  functionTimeout(),
+		checkTimeout: defaultCheckTimeout,
+
+		requestTimeout: functionTimeout(),
+		timeoutPolicy:  TimeoutCancel,
+		timeoutStatus:  http.StatusGatewayTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithReadTimeout sets the ReadTimeout of the HTTP servers.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = d
+	}
+}
+
+// WithReadHeaderTimeout sets the ReadHeaderTimeout of the HTTP servers.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.readHeaderTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the WriteTimeout of the HTTP servers. It should be
+// longer than the function timeout.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = d
+	}
+}
+
+// WithIdleTimeout sets the IdleTimeout of the HTTP servers.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = d
+	}
+}
+
+// WithMaxHeaderBytes sets the MaxHeaderBytes of the HTTP servers.
+func WithMaxHeaderBytes(n int) Option {
+	return func(o *options) {
+		o.maxHeaderBytes = n
+	}
+}
+
+// WithErrorLog sets the logger the HTTP servers report errors to. It defaults
+// to ErrorLogger.
+func WithErrorLog(l *log.Logger) Option {
+	return func(o *options) {
+		o.errorLog = l
+	}
+}
+
+// WithSocketHandler is like HandleSocket, but only applies to this call to
+// TakeOverWith.
+func WithSocketHandler(name string, handler http.Handler) Option {
+	return func(o *options) {
+		if o.socketHandlers == nil {
+			o.socketHandlers = map[string]http.Handler{}
+		}
+		o.socketHandlers[name] = handler
+	}
+}
+
+// WithLoadTimeout sets how long the /load endpoint waits for the init hooks
+// registered with AddInitHook. It defaults to the function timeout.
+func WithLoadTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.loadTimeout = d
+	}
+}
+
+// WithCheckTimeout sets how long each readiness or liveness check may run
+// before it is considered failed. It defaults to 5 seconds.
+func WithCheckTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.checkTimeout = d
+	}
+}
+
+// WithRequestTimeout sets the deadline of the context of every request passed
+// to the handler. It defaults to the function timeout, and 0 disables it.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = d
+	}
+}
+
+// WithTimeoutPolicy sets what happens to requests running past the request
+// timeout. It defaults to TimeoutCancel.
+func WithTimeoutPolicy(p TimeoutPolicy) Option {
+	return func(o *options) {
+		o.timeoutPolicy = p
+	}
+}
+
+// WithTimeoutStatus sets the status code of the responses sent by
+// TimeoutRespond, e.g. http.StatusRequestTimeout. It defaults to
+// http.StatusGatewayTimeout.
+func WithTimeoutStatus(code int) Option {
+	return func(o *options) {
+		o.timeoutStatus = code
+	}
+}
+
+// WithInternalOrigins restricts the /load and /check endpoints to the
+// supervisor, i.e. requests whose X-Google-Fetcher-Origin header is origin or
+// one of more. Other callers get a 403 status. Handlers can tell these
+// requests apart with FetcherOrigin.
+//
+// Any client can set the header: the origins should be values the front end
+// of the function never passes through from outside callers.
+func WithInternalOrigins(origin string, more ...string) Option {
+	return func(o *options) {
+		o.restrictInternal = true
+		o.internalOrigins = append([]string{origin}, more...)
+	}
+}
+
+// WithListener serves l instead of the sockets inherited from node or passed
+// with socket activation, or the address of the addr flag. It can be given
+// more than once to serve several listeners.
+func WithListener(l net.Listener) Option {
+	return func(o *options) {
+		o.listeners = append(o.listeners, l)
+	}
+}
+
+// WithContext makes TakeOverWith shut down gracefully when ctx is done and
+// then return, instead of doing so when the process receives SIGTERM or
+// SIGINT and exiting the process. Signals are then left to the caller, e.g.
+// to run several servers in tests.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// newServer returns a server for handler configured with the options.
+func (o *options) newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       o.readTimeout,
+		ReadHeaderTimeout: o.readHeaderTimeout,
+		WriteTimeout:      o.writeTimeout,
+		IdleTimeout:       o.idleTimeout,
+		MaxHeaderBytes:    o.maxHeaderBytes,
+		ErrorLog:          o.errorLog,
+	}
+}
+
+// socketHandler returns the handler for the socket with the given name, from
+// the options or registered with HandleSocket, or nil.
+func (o *options) socketHandler(name string) http.Handler {
+	if h, ok := o.socketHandlers[name]; ok {
+		return h
+	}
+	return socketHandler(name)
+}
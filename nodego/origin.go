@@ -0,0 +1,76 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"context"
+	"net/http"
+)
+
+// origin is the fetcher origin of a request.
+type origin struct {
+	name     string
+	internal bool
+}
+
+// WithFetcherOrigin returns a copy of ctx carrying the fetcher origin of a
+// request and whether it comes from the supervisor, e.g. to test handlers
+// calling FetcherOrigin.
+func WithFetcherOrigin(ctx context.Context, name string, internal bool) context.Context {
+	return context.WithValue(ctx, fetcherOriginKey, origin{name, internal})
+}
+
+// FetcherOrigin returns the X-Google-Fetcher-Origin header of the request
+// carried by ctx, and whether it identifies a call from the supervisor as
+// configured by WithInternalOrigins.
+func FetcherOrigin(ctx context.Context) (name string, internal bool) {
+	o, _ := ctx.Value(fetcherOriginKey).(origin)
+	return o.name, o.internal
+}
+
+// isInternalOrigin reports whether a request with the given fetcher origin
+// comes from the supervisor, i.e. is one of the origins given to
+// WithInternalOrigins.
+func (o *options) isInternalOrigin(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, n := range o.internalOrigins {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withFetcherOrigin stores the fetcher origin of the requests in their
+// context.
+func withFetcherOrigin(handler http.Handler, o *options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(fetcherOrigin)
+		ctx := WithFetcherOrigin(r.Context(), name, o.isInternalOrigin(name))
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allowInternal reports whether r may call an internal endpoint, rejecting it
+// with a 403 status otherwise.
+func (o *options) allowInternal(w http.ResponseWriter, r *http.Request) bool {
+	if _, internal := FetcherOrigin(r.Context()); o.restrictInternal && !internal {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInternalOrigins(t *testing.T) {
+	o := newOptions([]Option{WithInternalOrigins("supervisor")})
+	handler := withInternalEndpoints(http.NotFoundHandler(), startHealth(o), o)
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"supervisor", http.StatusOK},
+		{"", http.StatusForbidden},
+		{"spoofed", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/load", nil)
+		if tt.origin != "" {
+			req.Header.Set(fetcherOrigin, tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("origin %q: got status %d, want %d", tt.origin, rec.Code, tt.want)
+		}
+	}
+}
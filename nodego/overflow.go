@@ -0,0 +1,46 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import "fmt"
+
+// OverflowPolicy tells what happens to a log entry when the buffer of entries
+// waiting to be sent to the supervisor is full.
+type OverflowPolicy string
+
+// Supported overflow policies.
+const (
+	// OverflowBlock makes the logger wait until there is room in the buffer.
+	// A slow supervisor then stalls every handler that logs, for as long as
+	// the retries of a batch take.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest buffered entries to make room,
+	// or the entry being logged if only the batch being sent is buffered.
+	// It is the default.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest discards the entry being logged.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowSpill writes the entry being logged to stderr instead.
+	OverflowSpill OverflowPolicy = "stderr"
+)
+
+// ParseOverflowPolicy parses the name of an overflow policy.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(name); p {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown log overflow policy %q", name)
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"./supervisortest"
+)
+
+// testFunctionEnv makes the test binary run testFunction instead of the tests,
+// so that it can be started as a function by the fake supervisor.
+const testFunctionEnv = "NODEGO_TEST_FUNCTION"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testFunctionEnv) != "" {
+		testFunction()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// testFunction serves a handler logging the message in the msg query
+// parameter.
+func testFunction() {
+	flag.Parse()
+
+	http.HandleFunc(HTTPTrigger, WithLoggerFunc(func(w http.ResponseWriter, r *http.Request) {
+		InfoLoggerFromContext(r.Context()).Print(r.URL.Query().Get("msg"))
+		fmt.Fprintln(w, "OK")
+	}))
+	TakeOver()
+}
+
+// startTestFunction starts the test binary as a function against s.
+func startTestFunction(t *testing.T, s *supervisortest.Supervisor) *supervisortest.Function {
+	t.Helper()
+
+	f, err := s.StartFunction(os.Args[0],
+		testFunctionEnv+"=1",
+		"NODEGO_SUPERVISOR_RETRY_BASE_DELAY=10ms",
+		"NODEGO_SUPERVISOR_RETRY_MAX_DELAY=50ms",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// execute calls the function with the given execution ID and message.
+func execute(f *supervisortest.Function, id, msg string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", f.URL+HTTPTrigger+"?msg="+msg, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Function-Execution-Id", id)
+	return http.DefaultClient.Do(req)
+}
+
+func TestPipelineDeliversLogs(t *testing.T) {
+	s := supervisortest.NewSupervisor()
+	defer s.Close()
+
+	f := startTestFunction(t, s)
+	defer f.Stop(5 * time.Second)
+
+	resp, err := execute(f, "exec-1", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The logs of an execution are delivered before its response is sent.
+	var found *supervisortest.Entry
+	for _, e := range s.Entries() {
+		if strings.Contains(e.Payload(), "hello") {
+			found = &e
+			break
+		}
+	}
+	if found == nil {
+		t.Fatalf("the entry was not delivered before the response, got %v", s.Entries())
+	}
+	if found.ExecutionID != "exec-1" {
+		t.Errorf("got execution ID %q, want %q", found.ExecutionID, "exec-1")
+	}
+	if found.Severity != string(Info) {
+		t.Errorf("got severity %q, want %q", found.Severity, Info)
+	}
+
+	s.AssertNotKilled(t)
+}
+
+func TestPipelineKillsWhenSupervisorFails(t *testing.T) {
+	s := supervisortest.NewSupervisor()
+	defer s.Close()
+
+	f := startTestFunction(t, s)
+	defer f.Stop(5 * time.Second)
+
+	s.SetLogStatus(http.StatusInternalServerError)
+
+	// The function is killed while the request waits for its logs.
+	if resp, err := execute(f, "exec-2", "doomed"); err == nil {
+		resp.Body.Close()
+	}
+
+	s.AssertKilled(t, 10*time.Second)
+	if n := len(s.Batches()); n < 2 {
+		t.Errorf("got %d call(s) to /_ah/log, want the batch to be retried", n)
+	}
+
+	select {
+	case <-f.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the function did not exit after calling /_ah/kill")
+	}
+	if code, _ := f.Stop(time.Second); code != 16 {
+		t.Errorf("got exit code %d, want 16", code)
+	}
+}
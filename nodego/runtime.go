@@ -0,0 +1,177 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultFunctionTimeout is the function timeout used when
+// FUNCTION_TIMEOUT_SEC is not set, as in worker.js.
+const defaultFunctionTimeout = 60 * time.Second
+
+// Runtime describes the deployed function, as told by the environment set up
+// by Cloud Functions.
+type Runtime struct {
+	// Name is the name of the function (FUNCTION_NAME).
+	Name string
+	// TriggerType is the kind of trigger of the function, e.g. HTTP_TRIGGER
+	// (FUNCTION_TRIGGER_TYPE).
+	TriggerType string
+	// EntryPoint is the exported name of the function (ENTRY_POINT).
+	EntryPoint string
+	// CodeLocation is the directory the function is deployed to
+	// (CODE_LOCATION).
+	CodeLocation string
+	// Project is the ID of the project of the function (GCP_PROJECT).
+	Project string
+	// Region is the region the function runs in (FUNCTION_REGION).
+	Region string
+	// Timeout is how long an execution may run (FUNCTION_TIMEOUT_SEC). It
+	// defaults to 60 seconds.
+	Timeout time.Duration
+	// MemoryMB is the memory available to the function in megabytes
+	// (FUNCTION_MEMORY_MB), or 0 if unknown.
+	MemoryMB int
+}
+
+// RuntimeError lists the environment variables that could not be parsed by
+// LoadRuntime.
+type RuntimeError struct {
+	Errors []*RuntimeVarError
+}
+
+func (e *RuntimeError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "invalid runtime environment: " + strings.Join(msgs, "; ")
+}
+
+// RuntimeVarError is an invalid environment variable.
+type RuntimeVarError struct {
+	Name  string
+	Value string
+	Err   string
+}
+
+func (e *RuntimeVarError) Error() string {
+	return fmt.Sprintf("%s=%q: %s", e.Name, e.Value, e.Err)
+}
+
+// LoadRuntime reads the runtime from the environment. If some variables are
+// invalid, it returns a *RuntimeError listing all of them along with the
+// runtime, where the defaults are used in place of the invalid values.
+func LoadRuntime() (*Runtime, error) {
+	return LoadRuntimeFrom(os.LookupEnv)
+}
+
+// LoadRuntimeFrom is like LoadRuntime but reads the variables with lookup
+// instead of os.LookupEnv, e.g. to load a runtime from a map in tests.
+func LoadRuntimeFrom(lookup func(key string) (string, bool)) (*Runtime, error) {
+	get := func(key string) string {
+		v, _ := lookup(key)
+		return v
+	}
+
+	rt := &Runtime{
+		Name:         get("FUNCTION_NAME"),
+		TriggerType:  get("FUNCTION_TRIGGER_TYPE"),
+		EntryPoint:   get("ENTRY_POINT"),
+		CodeLocation: get("CODE_LOCATION"),
+		Project:      get("GCP_PROJECT"),
+		Region:       get("FUNCTION_REGION"),
+		Timeout:      defaultFunctionTimeout,
+	}
+
+	var errs []*RuntimeVarError
+	invalid := func(name, value, msg string) {
+		errs = append(errs, &RuntimeVarError{Name: name, Value: value, Err: msg})
+	}
+
+	if v := get("FUNCTION_TIMEOUT_SEC"); v != "" {
+		if sec, err := strconv.ParseInt(v, 10, 64); err != nil || sec <= 0 {
+			invalid("FUNCTION_TIMEOUT_SEC", v, "must be a positive number of seconds")
+		} else {
+			rt.Timeout = time.Duration(sec) * time.Second
+		}
+	}
+
+	if v := get("FUNCTION_MEMORY_MB"); v != "" {
+		if mb, err := strconv.Atoi(v); err != nil || mb <= 0 {
+			invalid("FUNCTION_MEMORY_MB", v, "must be a positive number of megabytes")
+		} else {
+			rt.MemoryMB = mb
+		}
+	}
+
+	if v := rt.CodeLocation; v != "" && !filepath.IsAbs(v) {
+		invalid("CODE_LOCATION", v, "must be an absolute path")
+		rt.CodeLocation = ""
+	}
+
+	if len(errs) > 0 {
+		return rt, &RuntimeError{Errors: errs}
+	}
+	return rt, nil
+}
+
+var (
+	runtimeMutex sync.RWMutex
+	runtimeInfo  = loadRuntimeAtInit()
+)
+
+// loadRuntimeAtInit loads the runtime for the process, reporting invalid
+// variables on stderr.
+func loadRuntimeAtInit() *Runtime {
+	rt, err := LoadRuntime()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v (using the defaults instead)\n", err)
+	}
+	return rt
+}
+
+// CurrentRuntime returns the runtime of the function. Handlers may call it
+// at any time.
+func CurrentRuntime() Runtime {
+	runtimeMutex.RLock()
+	defer runtimeMutex.RUnlock()
+	return *runtimeInfo
+}
+
+// SetRuntime replaces the runtime returned by CurrentRuntime, e.g. to test a
+// handler with a given timeout, and returns a function restoring the previous
+// one:
+//
+//	defer nodego.SetRuntime(nodego.Runtime{Timeout: time.Second})()
+func SetRuntime(rt Runtime) (restore func()) {
+	runtimeMutex.Lock()
+	prev := runtimeInfo
+	runtimeInfo = &rt
+	runtimeMutex.Unlock()
+
+	return func() {
+		runtimeMutex.Lock()
+		runtimeInfo = prev
+		runtimeMutex.Unlock()
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// lookupIn returns a lookup function reading the variables of env.
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestLoadRuntimeFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Runtime
+		invalid []string
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{},
+			want: Runtime{Timeout: defaultFunctionTimeout},
+		},
+		{
+			name: "all set",
+			env: map[string]string{
+				"FUNCTION_NAME":         "hello",
+				"FUNCTION_TRIGGER_TYPE": "HTTP_TRIGGER",
+				"ENTRY_POINT":           "helloWorld",
+				"CODE_LOCATION":         "/user_code",
+				"GCP_PROJECT":           "my-project",
+				"FUNCTION_REGION":       "us-central1",
+				"FUNCTION_TIMEOUT_SEC":  "540",
+				"FUNCTION_MEMORY_MB":    "2048",
+			},
+			want: Runtime{
+				Name:         "hello",
+				TriggerType:  "HTTP_TRIGGER",
+				EntryPoint:   "helloWorld",
+				CodeLocation: "/user_code",
+				Project:      "my-project",
+				Region:       "us-central1",
+				Timeout:      540 * time.Second,
+				MemoryMB:     2048,
+			},
+		},
+		{
+			name: "empty values",
+			env:  map[string]string{"FUNCTION_TIMEOUT_SEC": "", "FUNCTION_MEMORY_MB": "", "CODE_LOCATION": ""},
+			want: Runtime{Timeout: defaultFunctionTimeout},
+		},
+		{
+			name:    "timeout not a number",
+			env:     map[string]string{"FUNCTION_TIMEOUT_SEC": "1m", "GCP_PROJECT": "p"},
+			want:    Runtime{Project: "p", Timeout: defaultFunctionTimeout},
+			invalid: []string{"FUNCTION_TIMEOUT_SEC"},
+		},
+		{
+			name:    "zero timeout",
+			env:     map[string]string{"FUNCTION_TIMEOUT_SEC": "0"},
+			want:    Runtime{Timeout: defaultFunctionTimeout},
+			invalid: []string{"FUNCTION_TIMEOUT_SEC"},
+		},
+		{
+			name:    "negative timeout",
+			env:     map[string]string{"FUNCTION_TIMEOUT_SEC": "-5"},
+			want:    Runtime{Timeout: defaultFunctionTimeout},
+			invalid: []string{"FUNCTION_TIMEOUT_SEC"},
+		},
+		{
+			name:    "memory not a number",
+			env:     map[string]string{"FUNCTION_MEMORY_MB": "256MB"},
+			want:    Runtime{Timeout: defaultFunctionTimeout},
+			invalid: []string{"FUNCTION_MEMORY_MB"},
+		},
+		{
+			name:    "negative memory",
+			env:     map[string]string{"FUNCTION_MEMORY_MB": "-256"},
+			want:    Runtime{Timeout: defaultFunctionTimeout},
+			invalid: []string{"FUNCTION_MEMORY_MB"},
+		},
+		{
+			name:    "relative code location",
+			env:     map[string]string{"CODE_LOCATION": "user_code"},
+			want:    Runtime{Timeout: defaultFunctionTimeout},
+			invalid: []string{"CODE_LOCATION"},
+		},
+		{
+			name: "all invalid",
+			env: map[string]string{
+				"FUNCTION_TIMEOUT_SEC": "x",
+				"FUNCTION_MEMORY_MB":   "0",
+				"CODE_LOCATION":        "./src",
+				"FUNCTION_NAME":        "hello",
+			},
+			want:    Runtime{Name: "hello", Timeout: defaultFunctionTimeout},
+			invalid: []string{"FUNCTION_TIMEOUT_SEC", "FUNCTION_MEMORY_MB", "CODE_LOCATION"},
+		},
+	}
+	for _, tt := range tests {
+		rt, err := LoadRuntimeFrom(lookupIn(tt.env))
+		if rt == nil {
+			t.Errorf("%s: got no runtime", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(*rt, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *rt, tt.want)
+		}
+
+		if len(tt.invalid) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("%s: got error %v, want a *RuntimeError", tt.name, err)
+			continue
+		}
+		var names []string
+		for _, e := range rerr.Errors {
+			names = append(names, e.Name)
+			if e.Value != tt.env[e.Name] {
+				t.Errorf("%s: got value %q for %s, want %q", tt.name, e.Value, e.Name, tt.env[e.Name])
+			}
+		}
+		if !reflect.DeepEqual(names, tt.invalid) {
+			t.Errorf("%s: got invalid variables %v, want %v", tt.name, names, tt.invalid)
+		}
+	}
+}
+
+func TestRuntimeErrorMessage(t *testing.T) {
+	_, err := LoadRuntimeFrom(lookupIn(map[string]string{
+		"FUNCTION_TIMEOUT_SEC": "soon",
+		"FUNCTION_MEMORY_MB":   "0",
+		"CODE_LOCATION":        "src",
+	}))
+	want := `invalid runtime environment: ` +
+		`FUNCTION_TIMEOUT_SEC="soon": must be a positive number of seconds; ` +
+		`FUNCTION_MEMORY_MB="0": must be a positive number of megabytes; ` +
+		`CODE_LOCATION="src": must be an absolute path`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func TestSetRuntime(t *testing.T) {
+	prev := CurrentRuntime()
+	restore := SetRuntime(Runtime{Name: "test", Timeout: time.Second})
+	if rt := CurrentRuntime(); rt.Name != "test" || rt.Timeout != time.Second {
+		t.Errorf("got %+v after SetRuntime", rt)
+	}
+	restore()
+	if rt := CurrentRuntime(); !reflect.DeepEqual(rt, prev) {
+		t.Errorf("got %+v after restoring, want %+v", rt, prev)
+	}
+}
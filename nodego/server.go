@@ -0,0 +1,226 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Exit codes used by TakeOver when it shuts down after a signal.
+const (
+	// ExitShutdownOK means that all in-flight requests completed and all
+	// pending logs were delivered to the supervisor.
+	ExitShutdownOK = 0
+	// ExitShutdownTimeout means that in-flight requests were still running
+	// when the drain timeout elapsed.
+	ExitShutdownTimeout = 1
+	// ExitLogFlushFailed means that requests were drained but some logs could
+	// not be delivered to the supervisor.
+	ExitLogFlushFailed = 2
+)
+
+// endpoint is a listener along with the handler serving it.
+type endpoint struct {
+	l       net.Listener
+	handler http.Handler
+}
+
+// listenerEndpoints returns endpoints serving handler on every listener.
+func listenerEndpoints(listeners []net.Listener, handler http.Handler) []endpoint {
+	endpoints := make([]endpoint, len(listeners))
+	for i, l := range listeners {
+		endpoints[i] = endpoint{l: l, handler: handler}
+	}
+	return endpoints
+}
+
+// serve serves every endpoint with servers configured by o until all of them
+// fail or the process receives SIGTERM or SIGINT. In the latter case, serve
+// stops accepting connections, drains in-flight requests, flushes pending
+// logs and exits the process. If o has a context, serve does the same when it
+// is done instead, and returns rather than exiting.
+func serve(endpoints []endpoint, o *options) error {
+	var sigs chan os.Signal
+	var done <-chan struct{}
+	if o.ctx != nil {
+		done = o.ctx.Done()
+	} else {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+		defer signal.Stop(sigs)
+	}
+
+	var servers []*http.Server
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		srv := o.newServer(e.handler)
+		servers = append(servers, srv)
+
+		if _, ok := e.l.(*connListener); ok {
+			log.Println("Serving inherited connection on", e.l.Addr())
+		} else {
+			log.Println("Resuming HTTP server on", e.l.Addr())
+		}
+		wg.Add(1)
+		go func(l net.Listener) {
+			if err := srv.Serve(l); err != http.ErrServerClosed && err != errConnDone {
+				log.Println(err)
+			}
+			l.Close()
+			wg.Done()
+		}(e.l)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case sig := <-sigs:
+		log.Printf("Received %s, shutting down", sig)
+		os.Exit(shutdown(servers))
+	case <-done:
+		if code := shutdown(servers); code != ExitShutdownOK {
+			return fmt.Errorf("nodego: shutdown failed with exit code %d", code)
+		}
+	}
+	return nil
+}
+
+// shutdown gracefully stops all servers and flushes the logs, returning the
+// exit code the process should terminate with.
+func shutdown(servers []*http.Server) int {
+	ctx, cancel := context.WithTimeout(context.Background(), functionTimeout())
+	defer cancel()
+
+	code := ExitShutdownOK
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				fmt.Fprintln(os.Stderr, "Error draining requests:", err)
+				srv.Close()
+				mu.Lock()
+				code = ExitShutdownTimeout
+				mu.Unlock()
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	if err := loggingCtx.flush(supervisorLogTimeout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error flushing logs:", err)
+		if code == ExitShutdownOK {
+			code = ExitLogFlushFailed
+		}
+	}
+
+	return code
+}
+
+// errConnDone is returned by connListener.Accept once its connection is
+// closed.
+var errConnDone = errors.New("inherited connection closed")
+
+// connListener is a net.Listener that accepts a single connection that was
+// already accepted, e.g. by node before it execve'd this binary. Once the
+// connection is handed out, Accept blocks until the connection or the
+// listener is closed.
+type connListener struct {
+	conns     chan net.Conn
+	addr      net.Addr
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(c net.Conn) *connListener {
+	l := &connListener{
+		conns: make(chan net.Conn, 1),
+		addr:  c.LocalAddr(),
+		done:  make(chan struct{}),
+	}
+	l.conns <- &listenerConn{Conn: c, l: l}
+	return l
+}
+
+// Accept implements net.Listener.Accept.
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errConnDone
+	}
+}
+
+// Close implements net.Listener.Close. It does not close the connection if
+// it was accepted.
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr implements net.Listener.Addr.
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// listenerConn closes its connListener when it is closed.
+type listenerConn struct {
+	net.Conn
+	l *connListener
+}
+
+func (c *listenerConn) Close() error {
+	c.l.Close()
+	return c.Conn.Close()
+}
+
+// replayConn is a connection whose first bytes were already read, e.g. the
+// request node read before it execve'd this binary. Read returns them before
+// reading from the connection.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newReplayConn(c net.Conn, b []byte) *replayConn {
+	return &replayConn{Conn: c, r: io.MultiReader(bytes.NewReader(b), c)}
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// takeOver serves a handler answering name on a new listener until ctx is
+// done, returning the URL of the listener and the result of TakeOverWith.
+func takeOver(t *testing.T, ctx context.Context, name string) (string, <-chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- TakeOverWith(handler, WithListener(l), WithContext(ctx))
+	}()
+	return "http://" + l.Addr().String(), errc
+}
+
+func TestTakeOverWithListeners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	urls := map[string]string{}
+	var results []<-chan error
+	for _, name := range []string{"first", "second"} {
+		url, errc := takeOver(t, ctx, name)
+		urls[name] = url
+		results = append(results, errc)
+	}
+
+	for name, url := range urls {
+		resp, err := http.Get(url + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != name {
+			t.Errorf("%s: got %q, want %q", url, body, name)
+		}
+	}
+
+	cancel()
+	for _, errc := range results {
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Errorf("TakeOverWith: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("TakeOverWith did not return after its context was done")
+		}
+	}
+}
+
+func TestReplayConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The first request was read by node, the second is still pending.
+	accepted, dialed := connect(t, l)
+	if _, err := io.WriteString(dialed, "GET /second HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	read := "POST /first HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello"
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	})}
+	go srv.Serve(newConnListener(newReplayConn(accepted, []byte(read))))
+	defer srv.Close()
+
+	dialed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(dialed)
+	for _, want := range []string{"/first hello", "/second "} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("got %q, want %q", body, want)
+		}
+	}
+}
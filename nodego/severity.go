@@ -0,0 +1,77 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is the severity level of a log entry, as understood by Stackdriver
+// Logging.
+type Severity string
+
+// Supported severity levels, from lowest to highest.
+const (
+	Debug     Severity = "DEBUG"
+	Info      Severity = "INFO"
+	Notice    Severity = "NOTICE"
+	Warning   Severity = "WARNING"
+	Error     Severity = "ERROR"
+	Critical  Severity = "CRITICAL"
+	Alert     Severity = "ALERT"
+	Emergency Severity = "EMERGENCY"
+)
+
+// level returns the numeric value of the severity used by Stackdriver
+// Logging. Unknown severities are treated as DEFAULT, i.e. 0.
+func (s Severity) level() int {
+	switch s {
+	case Debug:
+		return 100
+	case Info:
+		return 200
+	case Notice:
+		return 300
+	case Warning:
+		return 400
+	case Error:
+		return 500
+	case Critical:
+		return 600
+	case Alert:
+		return 700
+	case Emergency:
+		return 800
+	}
+	return 0
+}
+
+// ParseSeverity parses a severity level name, ignoring case.
+func ParseSeverity(name string) (Severity, error) {
+	s := Severity(strings.ToUpper(strings.TrimSpace(name)))
+	if s.level() == 0 {
+		return "", fmt.Errorf("unknown log severity %q", name)
+	}
+	return s, nil
+}
+
+// enabled reports whether entries of this severity should be logged given the
+// minimum severity configured by the environment. Unknown severities are
+// always logged.
+func (s Severity) enabled() bool {
+	level := s.level()
+	return level == 0 || level >= minLogSeverity.level()
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Additional slog levels matching the supervisor severities that have no
+// slog counterpart.
+const (
+	LevelNotice    = slog.Level(2)
+	LevelCritical  = slog.Level(12)
+	LevelAlert     = slog.Level(16)
+	LevelEmergency = slog.Level(20)
+)
+
+// severityForLevel maps a slog level to the closest supervisor severity.
+func severityForLevel(l slog.Level) Severity {
+	switch {
+	case l < slog.LevelInfo:
+		return Debug
+	case l < LevelNotice:
+		return Info
+	case l < slog.LevelWarn:
+		return Notice
+	case l < slog.LevelError:
+		return Warning
+	case l < LevelCritical:
+		return Error
+	case l < LevelAlert:
+		return Critical
+	case l < LevelEmergency:
+		return Alert
+	}
+	return Emergency
+}
+
+// SlogHandler is a slog.Handler that sends records to the supervisor as
+// structured entries. Attributes become fields of the JSON payload, groups
+// become nested objects and the message is stored under the "message" key.
+//
+// Records are attributed to the execution carried by the context passed to
+// the logger, e.g. with slog.Logger.InfoContext(r.Context(), ...).
+type SlogHandler struct {
+	opts slog.HandlerOptions
+	goas []groupOrAttrs
+}
+
+// groupOrAttrs is either a group opened by WithGroup or attributes added by
+// WithAttrs.
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewSlogHandler returns a handler that sends records to the supervisor. If
+// opts is nil, the default options are used.
+func NewSlogHandler(opts *slog.HandlerOptions) *SlogHandler {
+	h := &SlogHandler{}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// Enabled implements slog.Handler.Enabled.
+func (h *SlogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return l >= minLevel && severityForLevel(l).enabled()
+}
+
+// Handle implements slog.Handler.Handle.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	entry, err := h.newEntry(ctx, r)
+	if err != nil {
+		return err
+	}
+	return writeEntry(entry)
+}
+
+// newEntry converts r to a structured entry.
+func (h *SlogHandler) newEntry(ctx context.Context, r slog.Record) (*logEntry, error) {
+	fields := map[string]interface{}{}
+
+	// Groups only apply to the attributes that follow them.
+	var groups []string
+	for _, goa := range h.goas {
+		if goa.group != "" {
+			groups = append(groups, goa.group)
+			continue
+		}
+		h.addAttrs(fields, groups, goa.attrs)
+	}
+
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	h.addAttrs(fields, groups, attrs)
+
+	fields["message"] = r.Message
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	id := ExecutionID(ctx)
+	if id == "" {
+		id = loggingCtx.executionID()
+	}
+
+	entry := &logEntry{
+		JSONPayload: payload,
+		Severity:    string(severityForLevel(r.Level)),
+		Time:        t.Format(isoTimeFormat),
+		ExecutionID: id,
+	}
+
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		entry.SourceLocation = &sourceLocation{
+			File:     frame.File,
+			Line:     int64(frame.Line),
+			Function: frame.Function,
+		}
+	}
+
+	return entry, nil
+}
+
+// WithAttrs implements slog.Handler.WithAttrs.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(groupOrAttrs{attrs: attrs})
+}
+
+// WithGroup implements slog.Handler.WithGroup.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(groupOrAttrs{group: name})
+}
+
+func (h *SlogHandler) with(goa groupOrAttrs) *SlogHandler {
+	h2 := *h
+	h2.goas = make([]groupOrAttrs, len(h.goas)+1)
+	copy(h2.goas, h.goas)
+	h2.goas[len(h.goas)] = goa
+	return &h2
+}
+
+// addAttrs adds attrs to the object found by following groups from fields.
+// Objects for the groups are only created if there is something to add.
+func (h *SlogHandler) addAttrs(fields map[string]interface{}, groups []string, attrs []slog.Attr) {
+	values := map[string]interface{}{}
+	for _, a := range attrs {
+		h.addAttr(values, groups, a)
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	m := fields
+	for _, g := range groups {
+		sub, ok := m[g].(map[string]interface{})
+		if !ok {
+			sub = map[string]interface{}{}
+			m[g] = sub
+		}
+		m = sub
+	}
+	for k, v := range values {
+		m[k] = v
+	}
+}
+
+// addAttr converts a to a JSON friendly value and stores it in m.
+func (h *SlogHandler) addAttr(m map[string]interface{}, groups []string, a slog.Attr) {
+	if rep := h.opts.ReplaceAttr; rep != nil && a.Value.Kind() != slog.KindGroup {
+		a = rep(groups, a)
+	}
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		sub := map[string]interface{}{}
+		for _, ga := range a.Value.Group() {
+			h.addAttr(sub, append(groups[:len(groups):len(groups)], a.Key), ga)
+		}
+		if len(sub) == 0 {
+			return
+		}
+		if a.Key == "" {
+			// Groups without a key are inlined.
+			for k, v := range sub {
+				m[k] = v
+			}
+			return
+		}
+		m[a.Key] = sub
+	case slog.KindTime:
+		m[a.Key] = a.Value.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		m[a.Key] = a.Value.Duration().String()
+	case slog.KindFloat64:
+		f := a.Value.Float64()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// JSON has no representation for them.
+			m[a.Key] = strconv.FormatFloat(f, 'g', -1, 64)
+			return
+		}
+		m[a.Key] = f
+	case slog.KindAny:
+		v := a.Value.Any()
+		if err, ok := v.(error); ok {
+			if _, ok := v.(json.Marshaler); !ok {
+				v = err.Error()
+			}
+		}
+		// Values that can't be encoded, e.g. channels, are logged as text
+		// rather than losing the whole record.
+		b, err := json.Marshal(v)
+		if err != nil {
+			m[a.Key] = fmt.Sprint(v)
+			return
+		}
+		m[a.Key] = json.RawMessage(b)
+	default:
+		m[a.Key] = a.Value.Any()
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slogEntry converts a record with the given attributes with h.
+func slogEntry(t *testing.T, h slog.Handler, ctx context.Context, level slog.Level, attrs ...slog.Attr) *logEntry {
+	t.Helper()
+
+	r := slog.NewRecord(time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC), level, "hello", 0)
+	r.AddAttrs(attrs...)
+	entry, err := h.(*SlogHandler).newEntry(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+// slogFields decodes the payload of the entry converted from a record with
+// the given attributes.
+func slogFields(t *testing.T, h slog.Handler, attrs ...slog.Attr) map[string]interface{} {
+	t.Helper()
+
+	entry := slogEntry(t, h, context.Background(), slog.LevelInfo, attrs...)
+	var fields map[string]interface{}
+	if err := json.Unmarshal(entry.JSONPayload, &fields); err != nil {
+		t.Fatalf("invalid payload %s: %v", entry.JSONPayload, err)
+	}
+	return fields
+}
+
+func TestSlogLevels(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  Severity
+	}{
+		{slog.LevelDebug, Debug},
+		{slog.LevelInfo, Info},
+		{slog.LevelInfo + 1, Info},
+		{LevelNotice, Notice},
+		{slog.LevelWarn, Warning},
+		{slog.LevelError, Error},
+		{LevelCritical, Critical},
+		{LevelAlert, Alert},
+		{LevelEmergency, Emergency},
+		{LevelEmergency + 10, Emergency},
+	}
+	for _, tt := range tests {
+		entry := slogEntry(t, NewSlogHandler(nil), context.Background(), tt.level)
+		if entry.Severity != string(tt.want) {
+			t.Errorf("level %v: got severity %q, want %q", tt.level, entry.Severity, tt.want)
+		}
+	}
+}
+
+func TestSlogEnabled(t *testing.T) {
+	h := NewSlogHandler(&slog.HandlerOptions{Level: slog.LevelWarn})
+	if h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("info records are enabled below the configured level")
+	}
+	if !h.Enabled(context.Background(), slog.LevelError) {
+		t.Error("error records are disabled above the configured level")
+	}
+}
+
+func TestSlogGroups(t *testing.T) {
+	h := NewSlogHandler(nil).
+		WithAttrs([]slog.Attr{slog.String("service", "api")}).
+		WithGroup("request").
+		WithAttrs([]slog.Attr{slog.String("method", "GET")}).
+		WithGroup("empty")
+
+	got := slogFields(t, h,
+		slog.Int("status", 200),
+		slog.Group("", slog.Bool("inlined", true)),
+	)
+	want := map[string]interface{}{
+		"message": "hello",
+		"service": "api",
+		"request": map[string]interface{}{
+			"method": "GET",
+			"empty": map[string]interface{}{
+				"status":  float64(200),
+				"inlined": true,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Groups without attributes are left out.
+	got = slogFields(t, NewSlogHandler(nil).WithGroup("unused"), slog.Group("none"))
+	if want := map[string]interface{}{"message": "hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSlogReplaceAttr(t *testing.T) {
+	var seen [][]string
+	h := NewSlogHandler(&slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			seen = append(seen, groups)
+			switch a.Key {
+			case "password":
+				return slog.Attr{}
+			case "user":
+				return slog.String("uid", strings.ToUpper(a.Value.String()))
+			}
+			return a
+		},
+	}).WithGroup("auth")
+
+	got := slogFields(t, h, slog.String("user", "u1"), slog.String("password", "secret"))
+	want := map[string]interface{}{
+		"message": "hello",
+		"auth":    map[string]interface{}{"uid": "U1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, groups := range seen {
+		if !reflect.DeepEqual(groups, []string{"auth"}) {
+			t.Errorf("ReplaceAttr got groups %v, want [auth]", groups)
+		}
+	}
+}
+
+func TestSlogValues(t *testing.T) {
+	got := slogFields(t, NewSlogHandler(nil),
+		slog.Duration("elapsed", 1500*time.Millisecond),
+		slog.Time("at", time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)),
+		slog.Any("err", errors.New("boom")),
+		slog.Float64("nan", math.NaN()),
+		slog.Float64("inf", math.Inf(-1)),
+		slog.Any("ch", make(chan int)),
+		slog.Any("nested", map[string]float64{"x": math.Inf(1)}),
+		slog.Any("list", []int{1, 2}),
+	)
+
+	want := map[string]interface{}{
+		"elapsed": "1.5s",
+		"at":      "2018-03-01T12:00:00Z",
+		"err":     "boom",
+		"nan":     "NaN",
+		"inf":     "-Inf",
+		"nested":  "map[x:+Inf]",
+		"list":    []interface{}{float64(1), float64(2)},
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(got[k], v) {
+			t.Errorf("%s: got %#v, want %#v", k, got[k], v)
+		}
+	}
+	if s, _ := got["ch"].(string); !strings.HasPrefix(s, "0x") {
+		t.Errorf("ch: got %#v, want the address of the channel", got["ch"])
+	}
+}
+
+func TestSlogExecutionID(t *testing.T) {
+	ctx := WithExecutionID(context.Background(), "exec-1")
+	entry := slogEntry(t, NewSlogHandler(nil), ctx, slog.LevelInfo)
+	if entry.ExecutionID != "exec-1" {
+		t.Errorf("got execution ID %q, want %q", entry.ExecutionID, "exec-1")
+	}
+	if want := "2018-03-01T12:00:00Z"; entry.Time != want {
+		t.Errorf("got time %q, want %q", entry.Time, want)
+	}
+}
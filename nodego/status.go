@@ -0,0 +1,181 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// ExecutionStatus is the outcome of an execution reported to the supervisor
+// with the X-Google-Status response header.
+type ExecutionStatus string
+
+// Execution statuses, as in worker.js.
+const (
+	// StatusOK means that the execution succeeded.
+	StatusOK ExecutionStatus = "ok"
+	// StatusError means that the function reported an error.
+	StatusError ExecutionStatus = "error"
+	// StatusCrash means that the function panicked.
+	StatusCrash ExecutionStatus = "crash"
+	// StatusTimeout means that the execution ran past the function timeout.
+	StatusTimeout ExecutionStatus = "timeout"
+)
+
+// SetExecutionStatus sets the status reported for the execution. It must be
+// called before the response header is written. Handlers only need it to
+// report an error along with a successful HTTP status, as WithExecutionStatus
+// otherwise derives the status from the response.
+func SetExecutionStatus(w http.ResponseWriter, s ExecutionStatus) {
+	w.Header().Set(functionStatusHeaderField, string(s))
+}
+
+// WithExecutionStatus returns an http.Handler that reports the outcome of
+// every execution to the supervisor with the X-Google-Status header, unless
+// the handler already set it:
+//
+//   - responses with a 5xx status are reported as "error", others as "ok";
+//   - panics are logged with their stack, answered with a 500 status and
+//     reported as "crash";
+//   - hijacked connections, e.g. for WebSockets, are not reported.
+//
+// TakeOver and TakeOverWith apply it to the handlers they serve.
+func WithExecutionStatus(handler http.Handler) http.Handler {
+	if _, ok := handler.(*statusHandler); ok {
+		return handler
+	}
+	return &statusHandler{handler}
+}
+
+type statusHandler struct {
+	handler http.Handler
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w}
+
+	defer func() {
+		p := recover()
+		if p == nil {
+			// The server writes a 200 status if the handler did not.
+			sw.setStatus(http.StatusOK)
+			return
+		}
+		if p == http.ErrAbortHandler {
+			panic(p)
+		}
+
+		id := r.Header.Get("Function-Execution-Id")
+		ErrorLoggerFromContext(WithExecutionID(r.Context(), id)).Printf("%v:\n\n%s\n", p, debug.Stack())
+
+		if !sw.wroteHeader {
+			SetExecutionStatus(w, StatusCrash)
+			http.Error(sw, "Function execution crashed", http.StatusInternalServerError)
+		}
+	}()
+
+	h.handler.ServeHTTP(sw, r)
+}
+
+// statusWriter sets the X-Google-Status header, if missing, when the response
+// header is written.
+type statusWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// setStatus sets the X-Google-Status header for a response with the given
+// code, unless the header was already written or set by the handler.
+func (w *statusWriter) setStatus(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if h.Get(functionStatusHeaderField) != "" {
+		return
+	}
+	if code >= 500 {
+		h.Set(functionStatusHeaderField, string(StatusError))
+	} else {
+		h.Set(functionStatusHeaderField, string(StatusOK))
+	}
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (w *statusWriter) WriteHeader(code int) {
+	// Informational responses don't carry the final header.
+	if code >= 200 {
+		w.setStatus(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter.Write.
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.setStatus(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher.Flush if the underlying writer supports it.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.setStatus(http.StatusOK)
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.Hijack if the underlying writer supports it.
+// No status is reported for a hijacked connection.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	c, rw, err := h.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return c, rw, err
+}
+
+// CloseNotify implements http.CloseNotifier.CloseNotify if the underlying
+// writer supports it. Otherwise, the returned channel never receives.
+func (w *statusWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// ReadFrom implements io.ReaderFrom.ReadFrom, using the underlying writer's
+// if it supports it, e.g. to send files with sendfile(2).
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.setStatus(http.StatusOK)
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusWriterHijack(t *testing.T) {
+	var serverLog bytes.Buffer
+	hijacked := make(chan error, 1)
+	srv := httptest.NewUnstartedServer(WithExecutionStatus(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		hijacked <- err
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString(line)
+		rw.Flush()
+	})))
+	srv.Config.ErrorLog = log.New(&serverLog, "", 0)
+	srv.Start()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-hijacked; err != nil {
+		t.Fatalf("Hijack: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := resp.Header.Get(functionStatusHeaderField); got != "" {
+		t.Errorf("got %s %q on a hijacked connection", functionStatusHeaderField, got)
+	}
+
+	io.WriteString(conn, "ping\n")
+	if line, _ := br.ReadString('\n'); line != "ping\n" {
+		t.Errorf("got %q echoed, want %q", line, "ping\n")
+	}
+
+	conn.Close()
+	srv.Close()
+	if serverLog.Len() > 0 {
+		t.Errorf("the server logged %q", serverLog.String())
+	}
+}
+
+func TestStatusWriterHijackNotSupported(t *testing.T) {
+	var err error
+	WithExecutionStatus(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err = w.(http.Hijacker).Hijack()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if err != http.ErrNotSupported {
+		t.Errorf("got error %v, want %v", err, http.ErrNotSupported)
+	}
+}
+
+func TestStatusWriterReadFrom(t *testing.T) {
+	srv := httptest.NewServer(WithExecutionStatus(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rf, ok := w.(io.ReaderFrom)
+		if !ok {
+			t.Error("the writer does not implement io.ReaderFrom")
+			return
+		}
+		rf.ReadFrom(strings.NewReader("copied"))
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "copied" {
+		t.Errorf("got body %q, want %q", body, "copied")
+	}
+	if got := resp.Header.Get(functionStatusHeaderField); got != string(StatusOK) {
+		t.Errorf("got %s %q, want %q", functionStatusHeaderField, got, StatusOK)
+	}
+
+	// Writers without ReadFrom are copied to.
+	rec := httptest.NewRecorder()
+	WithExecutionStatus(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("recorded"))
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "recorded" || rec.Header().Get(functionStatusHeaderField) != string(StatusOK) {
+		t.Errorf("got body %q and status %q", rec.Body.String(), rec.Header().Get(functionStatusHeaderField))
+	}
+}
+
+func TestStatusWriterCloseNotify(t *testing.T) {
+	closed := make(chan bool, 1)
+	srv := httptest.NewServer(WithExecutionStatus(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn, ok := w.(http.CloseNotifier)
+		if !ok {
+			t.Error("the writer does not implement http.CloseNotifier")
+			closed <- false
+			return
+		}
+		select {
+		case v := <-cn.CloseNotify():
+			closed <- v
+		case <-time.After(5 * time.Second):
+			closed <- false
+		}
+	})))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	if !<-closed {
+		t.Error("the handler was not notified that the client went away")
+	}
+}
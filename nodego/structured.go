@@ -0,0 +1,116 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"time"
+)
+
+// Entry is a structured log entry.
+type Entry struct {
+	// Severity is the severity level of the entry. It defaults to INFO.
+	Severity Severity
+	// Message is a human readable message. It is added to the payload under
+	// the "message" key.
+	Message string
+	// Payload is encoded as the JSON payload of the entry. It must encode to
+	// a JSON object, e.g. a struct or a map.
+	Payload interface{}
+	// Labels are arbitrary key/value pairs attached to the entry.
+	Labels map[string]string
+	// Trace is the trace the entry belongs to, in the form
+	// projects/{PROJECT}/traces/{TRACE_ID}.
+	Trace string
+}
+
+// Log sends a structured entry to the supervisor. The entry is attributed to
+// the execution carried by ctx and records the source location of the
+// caller.
+func Log(ctx context.Context, e Entry) error {
+	return logDepth(ctx, e, 1)
+}
+
+// logDepth is like Log but records the source location of the caller depth
+// frames above its own caller.
+func logDepth(ctx context.Context, e Entry, depth int) error {
+	payload, err := jsonPayload(e.Message, e.Payload)
+	if err != nil {
+		return err
+	}
+
+	severity := e.Severity
+	if severity == "" {
+		severity = Info
+	}
+
+	id := ExecutionID(ctx)
+	if id == "" {
+		id = loggingCtx.executionID()
+	}
+
+	entry := &logEntry{
+		JSONPayload: payload,
+		Severity:    string(severity),
+		Time:        time.Now().Format(isoTimeFormat),
+		ExecutionID: id,
+		Labels:      e.Labels,
+		Trace:       e.Trace,
+	}
+
+	if pc, file, line, ok := runtime.Caller(depth + 1); ok {
+		entry.SourceLocation = &sourceLocation{
+			File: file,
+			Line: int64(line),
+		}
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			entry.SourceLocation.Function = fn.Name()
+		}
+	}
+
+	return writeEntry(entry)
+}
+
+// jsonPayload encodes payload as a JSON object, adding message under the
+// "message" key if it is not empty.
+func jsonPayload(message string, payload interface{}) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return nil, errors.New("log payload must encode to a JSON object")
+		}
+		if fields == nil {
+			fields = map[string]json.RawMessage{}
+		}
+	}
+
+	if message != "" {
+		b, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		fields["message"] = b
+	}
+
+	return json.Marshal(fields)
+}
@@ -21,54 +21,250 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 type logEntry struct {
-	TextPayload string
-	Severity    string
-	Time        string
-	ExecutionID string
+	TextPayload    string          `json:",omitempty"`
+	JSONPayload    json.RawMessage `json:"JsonPayload,omitempty"`
+	Severity       string
+	Time           string
+	ExecutionID    string
+	Labels         map[string]string `json:",omitempty"`
+	SourceLocation *sourceLocation   `json:",omitempty"`
+	Trace          string            `json:",omitempty"`
+
+	// encodedLength caches the result of length.
+	encodedLength int
+}
+
+type sourceLocation struct {
+	File     string
+	Line     int64 `json:",string"`
+	Function string
+}
+
+// payload returns the text or JSON payload of the entry.
+func (e *logEntry) payload() string {
+	if len(e.JSONPayload) > 0 {
+		return string(e.JSONPayload)
+	}
+	return e.TextPayload
+}
+
+// length returns the number of bytes the entry counts for against
+// maxLogBatchLength: its size once encoded in a batch, including the comma
+// separating it from the next entry. The length is computed once, so the entry
+// must not be modified afterwards.
+func (e *logEntry) length() int {
+	if e.encodedLength == 0 {
+		b, err := json.Marshal(e)
+		if err != nil {
+			// Only invalid JSON payloads fail, and the report fails with
+			// them anyway.
+			return len(e.payload())
+		}
+		e.encodedLength = len(b) + 1
+	}
+	return e.encodedLength
+}
+
+// split breaks an entry whose payload exceeds maxLogLength into entries that
+// each fit in it. Text payloads are cut into text parts. JSON objects keep
+// their fields, shortened if needed, in a first structured part along with
+// the start of their message, and the rest of the message follows in text
+// parts. Every part but the last ends with continuationMarker and all parts
+// share a sequence ID label, so that the message can be reassembled.
+//
+// The labels, trace and other metadata of the entry are shortened first, so
+// that every part fits in a batch on its own.
+func (e *logEntry) split() []*logEntry {
+	e.truncateMetadata()
+
+	var first *logEntry
+	var rest string
+	switch {
+	case len(e.JSONPayload) > 0 && len(e.JSONPayload) <= maxLogLength:
+		return []*logEntry{e}
+	case len(e.JSONPayload) > 0:
+		first, rest = e.splitJSON()
+		if rest == "" {
+			return []*logEntry{first}
+		}
+	case len(e.TextPayload) <= maxLogLength:
+		return []*logEntry{e}
+	default:
+		rest = e.TextPayload
+	}
+
+	var parts []*logEntry
+	if first != nil {
+		parts = append(parts, first)
+	}
+	for _, chunk := range splitText(rest) {
+		part := *e
+		part.JSONPayload = nil
+		part.TextPayload = chunk
+		parts = append(parts, &part)
+	}
+
+	seq := nextSequenceID()
+	for i, part := range parts {
+		part.encodedLength = 0
+		if i < len(parts)-1 && len(part.JSONPayload) == 0 {
+			part.TextPayload += continuationMarker
+		}
+
+		part.Labels = make(map[string]string, len(e.Labels)+2)
+		for k, v := range e.Labels {
+			part.Labels[k] = v
+		}
+		part.Labels[sequenceLabel] = seq
+		part.Labels[partLabel] = fmt.Sprintf("%d/%d", i+1, len(parts))
+	}
+	return parts
+}
+
+// splitText cuts text into chunks that fit in maxLogLength once
+// continuationMarker is appended to all of them but the last.
+func splitText(text string) []string {
+	var chunks []string
+	for len(text) > 0 {
+		n := len(text)
+		if n > maxLogLength {
+			n = maxLogLength - len(continuationMarker)
+			// Avoid splitting a multi-byte character.
+			for n > 0 && !utf8.RuneStart(text[n]) {
+				n--
+			}
+			if n == 0 {
+				n = maxLogLength - len(continuationMarker)
+			}
+		}
+		chunks = append(chunks, text[:n])
+		text = text[n:]
+	}
+	return chunks
+}
+
+// splitJSON shortens the JSON payload of the entry to maxLogLength, returning
+// an entry with the shortened payload and the end of the message that didn't
+// fit in it. Payloads that are not JSON objects are returned as text.
+func (e *logEntry) splitJSON() (first *logEntry, rest string) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.JSONPayload, &fields); err != nil || fields == nil {
+		return nil, string(e.JSONPayload)
+	}
+
+	var message string
+	if raw, ok := fields["message"]; ok && json.Unmarshal(raw, &message) == nil {
+		delete(fields, "message")
+	}
+
+	// Leave at least half of the payload to the message.
+	maxFields := maxLogLength
+	if message != "" {
+		maxFields = maxLogLength / 2
+	}
+	truncateFields(fields, maxFields)
+
+	if message != "" {
+		// The room left by the fields and the "message" key.
+		room := maxLogLength - jsonObjectLength(fields) - len(`"message":`)
+		if len(fields) > 0 {
+			room -= len(",")
+		}
+
+		head := message
+		if jsonStringLength(message) > room {
+			head, rest = cutJSONString(message, room-len(continuationMarker))
+			head += continuationMarker
+		}
+		fields["message"], _ = json.Marshal(head)
+	}
+
+	part := *e
+	part.encodedLength = 0
+	part.TextPayload = ""
+	part.JSONPayload, _ = json.Marshal(fields)
+	return &part, rest
+}
+
+const (
+	// continuationMarker ends every part of a split message but the last.
+	continuationMarker = " [continued]"
+	// sequenceLabel is the label holding the ID shared by the parts of a
+	// split message.
+	sequenceLabel = "nodego/sequence"
+	// partLabel is the label holding the position of a part of a split
+	// message, e.g. 2/3.
+	partLabel = "nodego/part"
+)
+
+var sequenceCounter uint64
+
+// nextSequenceID returns an ID that is unique within the instance.
+func nextSequenceID() string {
+	return fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddUint64(&sequenceCounter, 1))
 }
 
 func (e *logEntry) consoleOutput() []byte {
+	payload := e.payload()
+
 	var logBuf bytes.Buffer
-	fmt.Fprintf(&logBuf, "[%s]", e.Severity[:1])
+	fmt.Fprintf(&logBuf, "[%s]", e.Severity)
 	fmt.Fprintf(&logBuf, "[%s]", time.Now().Format(isoTimeFormat))
 	if e.ExecutionID != "" {
 		fmt.Fprintf(&logBuf, "[%s]", e.ExecutionID)
 	}
 	logBuf.WriteByte(' ')
-	logBuf.WriteString(e.TextPayload)
-	if len(e.TextPayload) == 0 || e.TextPayload[len(e.TextPayload)-1] != '\n' {
+	logBuf.WriteString(payload)
+	if len(payload) == 0 || payload[len(payload)-1] != '\n' {
 		logBuf.WriteByte('\n')
 	}
 	return logBuf.Bytes()
 }
 
+// batchEnvelopeLength is the number of bytes of a batch that don't belong to
+// its entries, i.e. {"Entries":[]}.
+const batchEnvelopeLength = len(`{"Entries":[]}`)
+
 type logBatch struct {
 	Entries []*logEntry
 
-	payloadLength int
-	ready         chan struct{}
+	// encodedLength is the size of the batch once encoded as JSON, give or
+	// take a trailing comma.
+	encodedLength int
+	// reported is closed once the batch has been reported to the supervisor
+	// or dropped.
+	reported chan struct{}
 }
 
 // addEntry adds a log entry to the batch.
 //
 // Note: addEntry is not thread safe.
 func (b *logBatch) addEntry(entry *logEntry) {
-	if b.Entries == nil {
-		close(b.ready)
-	}
-
 	b.Entries = append(b.Entries, entry)
-	b.payloadLength += len(entry.TextPayload)
+	b.encodedLength += entry.length()
+}
+
+// dropOldestEntry removes the first entry of the batch.
+//
+// Note: dropOldestEntry is not thread safe.
+func (b *logBatch) dropOldestEntry() {
+	b.encodedLength -= b.Entries[0].length()
+	b.Entries[0] = nil
+	b.Entries = b.Entries[1:]
 }
 
 func (b *logBatch) report() error {
@@ -76,7 +272,7 @@ func (b *logBatch) report() error {
 		return nil
 	}
 
-	if err := postToSupervisor("/_ah/log", b, supervisorLogTimeout); err != nil {
+	if err := postToSupervisor("/_ah/log", b, supervisorLogTimeout, supervisorMaxRetries); err != nil {
 		return err
 	}
 
@@ -86,12 +282,34 @@ func (b *logBatch) report() error {
 type loggingContext struct {
 	initOnce sync.Once
 
-	queueMutex   sync.Mutex
-	queue        chan *logBatch
+	queueMutex sync.Mutex
+	// spaceAvailable is signaled when buffered entries are reported or
+	// dropped.
+	spaceAvailable *sync.Cond
+	// pending holds the full batches waiting to be reported, oldest first.
+	pending []*logBatch
+	// currentBatch is the batch receiving new entries.
 	currentBatch *logBatch
+	// buffered is the number of entries that were added but not yet
+	// reported, including the batch being reported.
+	buffered int
+	// notify wakes up the report worker when entries are added.
+	notify chan struct{}
+	// lastBatch is the most recent batch that received an entry.
+	lastBatch *logBatch
+	// execBatches maps execution IDs to the most recent batch that received
+	// an entry for that execution.
+	execBatches map[string]*logBatch
+
+	// executions counts the in-flight requests per execution ID. It is used
+	// to attribute messages from loggers that are not bound to a context.
+	executionsMutex sync.Mutex
+	executions      map[string]int
+}
 
-	execIDMutex sync.RWMutex
-	execID      string
+// active reports whether logs are sent to the supervisor.
+func (c *loggingContext) active() bool {
+	return c.notify != nil
 }
 
 // startNewBatch prepares a new batch.
@@ -99,83 +317,339 @@ type loggingContext struct {
 // Note: startNewBatch is not thread safe.
 func (c *loggingContext) startNewBatch() *logBatch {
 	c.currentBatch = &logBatch{
-		ready: make(chan struct{}),
+		encodedLength: batchEnvelopeLength,
+		reported:      make(chan struct{}),
 	}
-	c.queue <- c.currentBatch
 	return c.currentBatch
 }
 
-func (c *loggingContext) setExecutionID(id string) {
-	c.execIDMutex.Lock()
-	c.execID = id
-	c.execIDMutex.Unlock()
+func (c *loggingContext) beginExecution(id string) {
+	if id == "" {
+		return
+	}
+
+	c.executionsMutex.Lock()
+	if c.executions == nil {
+		c.executions = make(map[string]int)
+	}
+	c.executions[id]++
+	c.executionsMutex.Unlock()
+}
+
+func (c *loggingContext) endExecution(id string) {
+	if id == "" {
+		return
+	}
+
+	c.executionsMutex.Lock()
+	if c.executions[id]--; c.executions[id] <= 0 {
+		delete(c.executions, id)
+	}
+	c.executionsMutex.Unlock()
 }
 
+// executionID returns the ID of the only execution in flight. If there are no
+// executions or several of them, there is no way to tell which one a message
+// belongs to and executionID returns an empty string.
 func (c *loggingContext) executionID() string {
-	c.execIDMutex.RLock()
-	defer c.execIDMutex.RUnlock()
-	return c.execID
+	c.executionsMutex.Lock()
+	defer c.executionsMutex.Unlock()
+
+	if len(c.executions) != 1 {
+		return ""
+	}
+	for id := range c.executions {
+		return id
+	}
+	return ""
 }
 
+// addEntry buffers an entry until the report worker sends it. If the buffer
+// is full, the outcome depends on logOverflowPolicy.
 func (c *loggingContext) addEntry(entry *logEntry) bool {
-	if c.queue == nil {
+	if !c.active() {
 		return false
 	}
 
 	c.queueMutex.Lock()
 	defer c.queueMutex.Unlock()
 
+	for c.buffered > 0 && c.buffered >= maxBufferedLogEntries {
+		switch logOverflowPolicy {
+		case OverflowBlock:
+			c.spaceAvailable.Wait()
+			continue
+		case OverflowDropOldest:
+			if !c.dropOldest() {
+				// Only the batch being reported is buffered: drop the new
+				// entry rather than waiting for its retries.
+				atomic.AddUint64(&logStats.OverflowedEntries, 1)
+				return true
+			}
+			continue
+		case OverflowDropNewest:
+			atomic.AddUint64(&logStats.OverflowedEntries, 1)
+			return true
+		case OverflowSpill:
+			atomic.AddUint64(&logStats.SpilledEntries, 1)
+			os.Stderr.Write(entry.consoleOutput())
+			return true
+		}
+	}
+
 	// Start a new batch if the current one would grow too much.
 	if len(c.currentBatch.Entries) > 0 &&
 		(len(c.currentBatch.Entries)+1 > maxLogBatchEntries ||
-			c.currentBatch.payloadLength+len(entry.TextPayload) > maxLogBatchLength) {
+			c.currentBatch.encodedLength+entry.length() > maxLogBatchLength) {
+		c.pending = append(c.pending, c.currentBatch)
 		c.startNewBatch()
 	}
 
 	c.currentBatch.addEntry(entry)
+	c.buffered++
+	c.lastBatch = c.currentBatch
+	if entry.ExecutionID != "" {
+		c.execBatches[entry.ExecutionID] = c.currentBatch
+	}
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
 
 	return true
 }
 
-func (c *loggingContext) startReportWorker() {
-	for logBatch := range c.queue {
-		<-logBatch.ready
+// dropOldest discards the oldest batch waiting to be reported, or the oldest
+// entry of the current batch if there is none. It returns false if there is
+// nothing to discard but the batch being reported.
+//
+// Note: dropOldest is not thread safe.
+func (c *loggingContext) dropOldest() bool {
+	if len(c.pending) > 0 {
+		batch := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		atomic.AddUint64(&logStats.OverflowedEntries, uint64(len(batch.Entries)))
+		c.finishBatch(batch)
+		return true
+	}
 
-		c.queueMutex.Lock()
-		if logBatch == c.currentBatch {
-			c.startNewBatch()
+	if len(c.currentBatch.Entries) > 0 {
+		c.currentBatch.dropOldestEntry()
+		c.buffered--
+		atomic.AddUint64(&logStats.OverflowedEntries, 1)
+		return true
+	}
+
+	return false
+}
+
+// nextBatch returns the next batch to report, or nil if there is none.
+//
+// Note: nextBatch is not thread safe.
+func (c *loggingContext) nextBatch() *logBatch {
+	if len(c.pending) > 0 {
+		batch := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		return batch
+	}
+
+	if len(c.currentBatch.Entries) > 0 {
+		batch := c.currentBatch
+		c.startNewBatch()
+		return batch
+	}
+
+	return nil
+}
+
+// finishBatch releases the entries of a batch that was reported or dropped.
+//
+// Note: finishBatch is not thread safe.
+func (c *loggingContext) finishBatch(batch *logBatch) {
+	close(batch.reported)
+
+	for _, entry := range batch.Entries {
+		if c.execBatches[entry.ExecutionID] == batch {
+			delete(c.execBatches, entry.ExecutionID)
 		}
+	}
+
+	c.buffered -= len(batch.Entries)
+	c.spaceAvailable.Broadcast()
+}
+
+// flush waits until every entry added so far has been reported to the
+// supervisor or the timeout elapses.
+func (c *loggingContext) flush(timeout time.Duration) error {
+	if !c.active() {
+		return nil
+	}
+
+	c.queueMutex.Lock()
+	batch := c.lastBatch
+	c.queueMutex.Unlock()
+
+	return waitReported(batch, timeout)
+}
+
+// flushExecution waits until every entry added so far for the given execution
+// has been reported to the supervisor or the timeout elapses.
+func (c *loggingContext) flushExecution(id string, timeout time.Duration) error {
+	if !c.active() || id == "" {
+		return nil
+	}
+
+	c.queueMutex.Lock()
+	batch := c.execBatches[id]
+	delete(c.execBatches, id)
+	c.queueMutex.Unlock()
+
+	return waitReported(batch, timeout)
+}
+
+// waitReported waits until batch has been reported to the supervisor or the
+// timeout elapses. A nil batch is considered reported.
+func waitReported(batch *logBatch, timeout time.Duration) error {
+	if batch == nil {
+		return nil
+	}
+
+	// Batches are reported in order, so once a batch is reported all the
+	// previous ones are too.
+	select {
+	case <-batch.reported:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timeout when flushing logs")
+	}
+}
+
+func (c *loggingContext) startReportWorker() {
+	for {
+		c.queueMutex.Lock()
+		logBatch := c.nextBatch()
 		c.queueMutex.Unlock()
 
+		if logBatch == nil {
+			<-c.notify
+			continue
+		}
+
 		if err := logBatch.report(); err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
-			killInstance()
+			if isRetryable(err) {
+				// The retry budget is exhausted, the supervisor is gone.
+				killInstance()
+			}
+			// The supervisor rejected the batch, sending it again won't help.
+			atomic.AddUint64(&logStats.RejectedBatches, 1)
+			atomic.AddUint64(&logStats.RejectedEntries, uint64(len(logBatch.Entries)))
 		}
+
+		c.queueMutex.Lock()
+		c.finishBatch(logBatch)
+		c.queueMutex.Unlock()
 	}
 }
 
 func (c *loggingContext) initialize() {
 	c.initOnce.Do(func() {
-		c.queue = make(chan *logBatch, 5)
+		c.spaceAvailable = sync.NewCond(&c.queueMutex)
+		c.execBatches = make(map[string]*logBatch)
 		c.startNewBatch()
+		c.notify = make(chan struct{}, 1)
 		go c.startReportWorker()
 	})
 }
 
 var loggingCtx loggingContext
 
-var (
-	infoLogWriter  supervisorWriter = "INFO"
-	errorLogWriter supervisorWriter = "ERROR"
+// LogStats holds counters about the delivery of logs to the supervisor.
+type LogStats struct {
+	// Retries is the number of calls to the supervisor that were retried.
+	Retries uint64
+	// RejectedBatches is the number of batches rejected by the supervisor.
+	RejectedBatches uint64
+	// RejectedEntries is the number of entries in those batches.
+	RejectedEntries uint64
+	// OverflowedEntries is the number of entries dropped because the log
+	// buffer was full.
+	OverflowedEntries uint64
+	// SpilledEntries is the number of entries written to stderr because the
+	// log buffer was full.
+	SpilledEntries uint64
+}
+
+var logStats LogStats
+
+// ReadLogStats returns a snapshot of the log delivery counters.
+func ReadLogStats() LogStats {
+	return LogStats{
+		Retries:           atomic.LoadUint64(&logStats.Retries),
+		RejectedBatches:   atomic.LoadUint64(&logStats.RejectedBatches),
+		RejectedEntries:   atomic.LoadUint64(&logStats.RejectedEntries),
+		OverflowedEntries: atomic.LoadUint64(&logStats.OverflowedEntries),
+		SpilledEntries:    atomic.LoadUint64(&logStats.SpilledEntries),
+	}
+}
 
+var (
+	// DebugLogger is a logger that batches sends logs to the supervisor with a
+	// severity level of DEBUG.
+	DebugLogger = log.New(supervisorWriter{severity: Debug}, "", 0)
 	// InfoLogger is a logger that batches sends logs to the supervisor with a
 	// severity level of INFO.
-	InfoLogger = log.New(infoLogWriter, "", 0)
-	// InfoLogger is a logger that batches sends logs to the supervisor with a
+	//
+	// Messages are only attributed to an execution when a single one is in
+	// flight. Use InfoLoggerFromContext when requests are served concurrently.
+	InfoLogger = log.New(supervisorWriter{severity: Info}, "", 0)
+	// NoticeLogger is a logger that batches sends logs to the supervisor with a
+	// severity level of NOTICE.
+	NoticeLogger = log.New(supervisorWriter{severity: Notice}, "", 0)
+	// WarningLogger is a logger that batches sends logs to the supervisor with
+	// a severity level of WARNING.
+	WarningLogger = log.New(supervisorWriter{severity: Warning}, "", 0)
+	// ErrorLogger is a logger that batches sends logs to the supervisor with a
 	// severity level of ERROR.
-	ErrorLogger = log.New(errorLogWriter, "", 0)
+	//
+	// Messages are only attributed to an execution when a single one is in
+	// flight. Use ErrorLoggerFromContext when requests are served concurrently.
+	ErrorLogger = log.New(supervisorWriter{severity: Error}, "", 0)
+	// CriticalLogger is a logger that batches sends logs to the supervisor with
+	// a severity level of CRITICAL.
+	CriticalLogger = log.New(supervisorWriter{severity: Critical}, "", 0)
+	// AlertLogger is a logger that batches sends logs to the supervisor with a
+	// severity level of ALERT.
+	AlertLogger = log.New(supervisorWriter{severity: Alert}, "", 0)
+	// EmergencyLogger is a logger that batches sends logs to the supervisor
+	// with a severity level of EMERGENCY.
+	EmergencyLogger = log.New(supervisorWriter{severity: Emergency}, "", 0)
 )
 
+// LoggerFromContext returns a logger with the given severity level whose
+// messages are attributed to the execution carried by ctx.
+func LoggerFromContext(ctx context.Context, severity Severity) *log.Logger {
+	return log.New(supervisorWriter{
+		severity:    severity,
+		executionID: ExecutionID(ctx),
+	}, "", 0)
+}
+
+// InfoLoggerFromContext returns a logger like InfoLogger whose messages are
+// attributed to the execution carried by ctx.
+func InfoLoggerFromContext(ctx context.Context) *log.Logger {
+	return LoggerFromContext(ctx, Info)
+}
+
+// ErrorLoggerFromContext returns a logger like ErrorLogger whose messages are
+// attributed to the execution carried by ctx.
+func ErrorLoggerFromContext(ctx context.Context) *log.Logger {
+	return LoggerFromContext(ctx, Error)
+}
+
 func init() {
 	if supervisorHostname != "" && supervisorInternalPort != "" {
 		loggingCtx.initialize()
@@ -184,24 +658,52 @@ func init() {
 
 const isoTimeFormat = "2006-01-02T15:04:05.999Z07:00"
 
-type supervisorWriter string
+type supervisorWriter struct {
+	severity Severity
+	// executionID is the execution the messages belong to. If empty, the
+	// execution is guessed from the ones in flight.
+	executionID string
+}
 
 // Write implements io.Writer.Write.
 func (w supervisorWriter) Write(p []byte) (int, error) {
+	id := w.executionID
+	if id == "" {
+		id = loggingCtx.executionID()
+	}
+
 	entry := &logEntry{
 		TextPayload: string(p),
-		Severity:    string(w),
+		Severity:    string(w.severity),
 		Time:        time.Now().Format(isoTimeFormat),
-		ExecutionID: loggingCtx.executionID(),
+		ExecutionID: id,
 	}
 
-	if !loggingCtx.addEntry(entry) {
-		return os.Stderr.Write(entry.consoleOutput())
+	if err := writeEntry(entry); err != nil {
+		return 0, err
 	}
 
 	return len(entry.TextPayload), nil
 }
 
+// writeEntry sends the entry to the supervisor, or prints it to stderr when
+// there is no supervisor. Entries below the minimum severity are dropped.
+func writeEntry(entry *logEntry) error {
+	if !Severity(entry.Severity).enabled() {
+		return nil
+	}
+
+	if !loggingCtx.active() {
+		_, err := os.Stderr.Write(entry.consoleOutput())
+		return err
+	}
+
+	for _, part := range entry.split() {
+		loggingCtx.addEntry(part)
+	}
+	return nil
+}
+
 func newSupervisorRequest(path string, v interface{}) (*http.Request, error) {
 	postData, err := json.Marshal(v)
 	if err != nil {
@@ -235,7 +737,56 @@ func doRequestWithContext(ctx context.Context, r *http.Request) (*http.Response,
 	return resp, err
 }
 
-func postToSupervisor(path string, v interface{}, timeout time.Duration) error {
+// supervisorError is an error returned by a call to the supervisor.
+type supervisorError struct {
+	msg string
+	// retryable is true if the call may succeed when retried, e.g. after a
+	// timeout or a 5xx response.
+	retryable bool
+}
+
+func (e *supervisorError) Error() string {
+	return e.msg
+}
+
+// isRetryable reports whether err is a supervisor error worth retrying.
+func isRetryable(err error) bool {
+	serr, ok := err.(*supervisorError)
+	return ok && serr.retryable
+}
+
+// postToSupervisor posts v to the supervisor, retrying up to retries times
+// with exponential backoff when the error is retryable. The timeout applies
+// to each attempt.
+func postToSupervisor(path string, v interface{}, timeout time.Duration, retries int) error {
+	for attempt := 0; ; attempt++ {
+		err := postToSupervisorOnce(path, v, timeout)
+		if err == nil || !isRetryable(err) || attempt >= retries {
+			return err
+		}
+
+		atomic.AddUint64(&logStats.Retries, 1)
+		fmt.Fprintf(os.Stderr, "Retrying call to supervisor after attempt %d: %s\n", attempt+1, strings.TrimSpace(err.Error()))
+		time.Sleep(retryDelay(attempt))
+	}
+}
+
+// retryDelay returns the delay before the given retry attempt, using
+// exponential backoff with full jitter.
+func retryDelay(attempt int) time.Duration {
+	d := supervisorRetryMaxDelay
+	if attempt < 32 {
+		if backoff := supervisorRetryBaseDelay << uint(attempt); backoff > 0 && backoff < d {
+			d = backoff
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func postToSupervisorOnce(path string, v interface{}, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
@@ -247,20 +798,29 @@ func postToSupervisor(path string, v interface{}, timeout time.Duration) error {
 	resp, err := doRequestWithContext(ctx, req)
 	if err != nil {
 		if err == ctx.Err() {
-			return errors.New("timeout when calling supervisor")
+			return &supervisorError{msg: "timeout when calling supervisor", retryable: true}
+		}
+		return &supervisorError{
+			msg:       fmt.Sprintf("error when calling supervisor: %s\n\n%s\n", err.Error(), debug.Stack()),
+			retryable: true,
 		}
-		return fmt.Errorf("error when calling supervisor: %s\n\n%s\n", err.Error(), debug.Stack())
 	}
+	resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		err = fmt.Errorf("incorrect response code from supervisor: %d\n", resp.StatusCode)
+		return &supervisorError{
+			msg: fmt.Sprintf("incorrect response code from supervisor: %d\n", resp.StatusCode),
+			retryable: resp.StatusCode >= 500 ||
+				resp.StatusCode == http.StatusRequestTimeout ||
+				resp.StatusCode == http.StatusTooManyRequests,
+		}
 	}
 
-	return err
+	return nil
 }
 
 func killInstance() {
-	err := postToSupervisor("/_ah/kill", nil, supervisorKillTimeout)
+	err := postToSupervisor("/_ah/kill", nil, supervisorKillTimeout, 0)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 	}
@@ -270,18 +830,30 @@ func killInstance() {
 
 func loggerMiddleware(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		loggingCtx.setExecutionID(r.Header.Get("Function-Execution-Id"))
+		id := r.Header.Get("Function-Execution-Id")
+		loggingCtx.beginExecution(id)
 
 		defer func() {
-			loggingCtx.setExecutionID("")
+			// The response is only sent once the handler returns, so flushing
+			// here makes sure the logs reach the supervisor before the
+			// instance can be frozen.
+			if err := loggingCtx.flushExecution(id, logFlushTimeout); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+			}
+			loggingCtx.endExecution(id)
 		}()
 
-		handler.ServeHTTP(w, r)
+		handler.ServeHTTP(w, r.WithContext(WithExecutionID(r.Context(), id)))
 	}
 }
 
 // WithLogger returns an http.Handler that reads the function execution ID,
-// attaches it to log messages sent to the supervisor.
+// attaches it to log messages sent to the supervisor and waits for those
+// messages to be delivered before the response is sent.
+//
+// The execution ID is also stored in the request context, so that loggers
+// returned by InfoLoggerFromContext and ErrorLoggerFromContext attribute their
+// messages correctly even when requests are served concurrently.
 func WithLogger(handler http.Handler) http.Handler {
 	return loggerMiddleware(handler)
 }
@@ -294,6 +866,6 @@ func WithLoggerFunc(handler http.HandlerFunc) http.HandlerFunc {
 // OverrideLogger sets the default logger output to the supervisor logger with
 // a severity level of INFO.
 func OverrideLogger() {
-	log.SetOutput(infoLogWriter)
+	log.SetOutput(InfoLogger.Writer())
 	log.SetFlags(0)
 }
@@ -0,0 +1,267 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLoggingContext returns a logging context buffering entries without
+// reporting them.
+func newTestLoggingContext() *loggingContext {
+	c := &loggingContext{
+		execBatches: make(map[string]*logBatch),
+		notify:      make(chan struct{}, 1),
+	}
+	c.spaceAvailable = sync.NewCond(&c.queueMutex)
+	c.startNewBatch()
+	return c
+}
+
+func TestBatchesFitInMaxLength(t *testing.T) {
+	c := newTestLoggingContext()
+
+	// Quotes and control characters grow when escaped, so the raw payloads
+	// are much smaller than the encoded batch.
+	payload := strings.Repeat("\"\x01", 40)
+	for i := 0; i < 3*maxLogBatchEntries; i++ {
+		c.addEntry(&logEntry{
+			TextPayload: payload,
+			Severity:    string(Info),
+			Time:        time.Now().Format(isoTimeFormat),
+			ExecutionID: "execution-id",
+			Labels:      map[string]string{"key": "value"},
+		})
+	}
+
+	batches, entries := 0, 0
+	for b := c.nextBatch(); b != nil; b = c.nextBatch() {
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) > maxLogBatchLength {
+			t.Errorf("batch of %d entries is %d bytes long, want at most %d", len(b.Entries), len(encoded), maxLogBatchLength)
+		}
+		if len(b.Entries) > maxLogBatchEntries {
+			t.Errorf("batch has %d entries, want at most %d", len(b.Entries), maxLogBatchEntries)
+		}
+		batches++
+		entries += len(b.Entries)
+	}
+
+	if entries != 3*maxLogBatchEntries {
+		t.Errorf("got %d entries, want %d", entries, 3*maxLogBatchEntries)
+	}
+	if batches <= 3 {
+		t.Errorf("got %d batches, want the entries to be split on length", batches)
+	}
+}
+
+// checkParts checks that every part fits in maxLogLength and in a batch of its
+// own, and that they share a sequence label.
+func checkParts(t *testing.T, parts []*logEntry) {
+	t.Helper()
+
+	seq := parts[0].Labels[sequenceLabel]
+	for i, part := range parts {
+		if n := len(part.payload()); n > maxLogLength {
+			t.Errorf("part %d: payload is %d bytes long, want at most %d", i, n, maxLogLength)
+		}
+		if n := part.length(); n > maxLogBatchLength-batchEnvelopeLength {
+			t.Errorf("part %d: entry is %d bytes long, want at most %d", i, n, maxLogBatchLength-batchEnvelopeLength)
+		}
+		if len(parts) > 1 {
+			if part.Labels[sequenceLabel] != seq || seq == "" {
+				t.Errorf("part %d: got sequence %q, want %q", i, part.Labels[sequenceLabel], seq)
+			}
+			if want := fmt.Sprintf("%d/%d", i+1, len(parts)); part.Labels[partLabel] != want {
+				t.Errorf("part %d: got part label %q, want %q", i, part.Labels[partLabel], want)
+			}
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	message := strings.Repeat("héllo wörld ", 1500)
+	parts := (&logEntry{TextPayload: message, Severity: string(Info)}).split()
+	if len(parts) < 2 {
+		t.Fatalf("got %d part(s), want the message to be split", len(parts))
+	}
+	checkParts(t, parts)
+
+	var b strings.Builder
+	for i, part := range parts {
+		text := part.TextPayload
+		if i < len(parts)-1 {
+			if !strings.HasSuffix(text, continuationMarker) {
+				t.Errorf("part %d does not end with the continuation marker", i)
+			}
+			text = strings.TrimSuffix(text, continuationMarker)
+		}
+		b.WriteString(text)
+	}
+	if b.String() != message {
+		t.Error("the parts don't add up to the message")
+	}
+}
+
+func TestSplitJSONMessage(t *testing.T) {
+	// Escaped characters make the encoded message longer than the raw one.
+	message := strings.Repeat(`<"quoted"> `, 1200)
+	payload, err := jsonPayload(message, map[string]interface{}{"user": "u1", "count": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := (&logEntry{JSONPayload: payload, Severity: string(Info)}).split()
+	if len(parts) < 2 {
+		t.Fatalf("got %d part(s), want the message to be split", len(parts))
+	}
+	checkParts(t, parts)
+
+	var fields struct {
+		Message string
+		User    string
+		Count   int
+	}
+	if err := json.Unmarshal(parts[0].JSONPayload, &fields); err != nil {
+		t.Fatalf("the first part is not a JSON object: %v", err)
+	}
+	if fields.User != "u1" || fields.Count != 3 {
+		t.Errorf("got fields %+v, want them kept in the first part", fields)
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(fields.Message, continuationMarker))
+	for i, part := range parts[1:] {
+		if len(part.JSONPayload) != 0 {
+			t.Fatalf("part %d: got a JSON payload, want text", i+1)
+		}
+		b.WriteString(strings.TrimSuffix(part.TextPayload, continuationMarker))
+	}
+	if b.String() != message {
+		t.Error("the parts don't add up to the message")
+	}
+}
+
+func TestSplitJSONFields(t *testing.T) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"blob":   strings.Repeat("&", 1000),
+		"nested": map[string]string{"data": strings.Repeat("x", 6000)},
+		"id":     "x1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := (&logEntry{JSONPayload: payload, Severity: string(Info)}).split()
+	if len(parts) != 1 {
+		t.Fatalf("got %d parts, want the fields to be truncated in place", len(parts))
+	}
+	checkParts(t, parts)
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(parts[0].JSONPayload, &fields); err != nil {
+		t.Fatalf("the payload is not a JSON object: %v", err)
+	}
+	if fields["id"] != "x1" {
+		t.Errorf("got id %v, want the short field to be kept", fields["id"])
+	}
+	for _, key := range []string{"blob", "nested"} {
+		if s, _ := fields[key].(string); !strings.HasSuffix(s, truncationMarker) {
+			t.Errorf("%s: got %.20q, want a truncated string", key, fields[key])
+		}
+	}
+}
+
+func TestSplitBoundsMetadata(t *testing.T) {
+	labels := map[string]string{}
+	for i := 0; i < 500; i++ {
+		labels[fmt.Sprintf("%d%s", i, strings.Repeat("k", 200))] = strings.Repeat("\x01", 2000)
+	}
+
+	entry := &logEntry{
+		TextPayload: strings.Repeat("\x01", 3*maxLogLength),
+		Severity:    string(Info),
+		ExecutionID: strings.Repeat("e", 5000),
+		Labels:      labels,
+		Trace:       strings.Repeat("t", 200000),
+		SourceLocation: &sourceLocation{
+			File:     strings.Repeat("f", 5000),
+			Function: strings.Repeat("<", 5000),
+		},
+	}
+	parts := entry.split()
+	checkParts(t, parts)
+
+	if n := len(parts[0].Labels); n != maxLogLabels+2 {
+		t.Errorf("got %d labels, want %d", n, maxLogLabels+2)
+	}
+	if len(labels) != 500 {
+		t.Error("the labels of the caller were modified")
+	}
+}
+
+func TestDropOldestNeverWaitsForReport(t *testing.T) {
+	defer func(n int, p OverflowPolicy) {
+		maxBufferedLogEntries, logOverflowPolicy = n, p
+	}(maxBufferedLogEntries, logOverflowPolicy)
+	maxBufferedLogEntries, logOverflowPolicy = 2, OverflowDropOldest
+
+	c := newTestLoggingContext()
+	newEntry := func() *logEntry {
+		return &logEntry{TextPayload: "hello", Severity: string(Info)}
+	}
+	c.addEntry(newEntry())
+	c.addEntry(newEntry())
+
+	// The only buffered entries are being reported.
+	c.queueMutex.Lock()
+	reporting := c.nextBatch()
+	c.queueMutex.Unlock()
+
+	overflowed := ReadLogStats().OverflowedEntries
+	done := make(chan struct{})
+	go func() {
+		c.addEntry(newEntry())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging waited for the batch being reported")
+	}
+
+	if n := ReadLogStats().OverflowedEntries - overflowed; n != 1 {
+		t.Errorf("got %d overflowed entries, want the new entry dropped", n)
+	}
+	if len(reporting.Entries) != 2 {
+		t.Errorf("the batch being reported has %d entries, want 2", len(reporting.Entries))
+	}
+
+	c.queueMutex.Lock()
+	c.finishBatch(reporting)
+	c.queueMutex.Unlock()
+	c.addEntry(newEntry())
+	if c.buffered != 1 {
+		t.Errorf("got %d buffered entries after the report, want 1", c.buffered)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisortest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// startTimeout is how long StartFunction waits for the function to listen.
+const startTimeout = 10 * time.Second
+
+// Function is a function binary running against a fake supervisor.
+type Function struct {
+	// URL is the base URL the function is served on, e.g.
+	// http://127.0.0.1:12345.
+	URL string
+
+	cmd    *exec.Cmd
+	output syncBuffer
+	done   chan struct{}
+	err    error
+}
+
+// StartFunction starts a function binary built without the node tag, with
+// the environment pointing at the supervisor. The extra environment
+// variables, in the form "KEY=value", are added to the environment of the
+// current process. StartFunction returns once the function accepts
+// connections.
+func (s *Supervisor) StartFunction(binary string, env ...string) (*Function, error) {
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Function{
+		URL:  "http://" + addr,
+		cmd:  exec.Command(binary, "-addr="+addr),
+		done: make(chan struct{}),
+	}
+	f.cmd.Env = append(append(os.Environ(), s.Env()...), env...)
+	f.cmd.Stdout = &f.output
+	f.cmd.Stderr = &f.output
+
+	if err := f.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		f.err = f.cmd.Wait()
+		close(f.done)
+	}()
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return f, nil
+		}
+
+		select {
+		case <-f.done:
+			return nil, fmt.Errorf("function exited before listening: %v\n%s", f.err, f.Output())
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			f.cmd.Process.Kill()
+			return nil, fmt.Errorf("function did not listen on %s:\n%s", addr, f.Output())
+		}
+	}
+}
+
+// Done returns a channel that is closed once the function exits.
+func (f *Function) Done() <-chan struct{} {
+	return f.done
+}
+
+// Output returns what the function wrote to stdout and stderr so far.
+func (f *Function) Output() string {
+	return f.output.String()
+}
+
+// Stop sends SIGTERM to the function and waits for it to exit, returning its
+// exit code. The function is killed if it does not exit within the timeout.
+func (f *Function) Stop(timeout time.Duration) (int, error) {
+	if err := f.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		select {
+		case <-f.done:
+		default:
+			return -1, err
+		}
+	}
+
+	select {
+	case <-f.done:
+	case <-time.After(timeout):
+		f.cmd.Process.Kill()
+		<-f.done
+		return -1, fmt.Errorf("function did not exit within %s", timeout)
+	}
+
+	return f.cmd.ProcessState.ExitCode(), nil
+}
+
+// freeAddr returns a local address with a port that is currently free.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,288 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package supervisortest provides a fake of the Cloud Functions supervisor
+// for end-to-end testing of functions built with nodego.
+//
+// The fake serves the /_ah/log and /_ah/kill endpoints, records what it
+// receives and can start a function binary with the environment pointing at
+// itself:
+//
+//	s := supervisortest.NewSupervisor()
+//	defer s.Close()
+//
+//	f, err := s.StartFunction("./main")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer f.Stop(5 * time.Second)
+//
+//	http.Get(f.URL + "/execute")
+//	s.AssertLogged(t, "INFO", "Hello", 5*time.Second)
+package supervisortest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceLocation is the source location of a log entry.
+type SourceLocation struct {
+	File     string
+	Line     int64 `json:",string"`
+	Function string
+}
+
+// Entry is a log entry received by the supervisor.
+type Entry struct {
+	TextPayload    string
+	JSONPayload    json.RawMessage `json:"JsonPayload"`
+	Severity       string
+	Time           string
+	ExecutionID    string
+	Labels         map[string]string
+	SourceLocation *SourceLocation
+	Trace          string
+}
+
+// Payload returns the text payload of the entry, or its JSON payload if it
+// has one.
+func (e Entry) Payload() string {
+	if len(e.JSONPayload) > 0 {
+		return string(e.JSONPayload)
+	}
+	return e.TextPayload
+}
+
+// Batch is a batch of log entries received by the supervisor.
+type Batch struct {
+	Entries []Entry
+}
+
+// Supervisor is a fake supervisor recording the calls made by a function.
+type Supervisor struct {
+	// Host and Port are the values of the SUPERVISOR_HOSTNAME and
+	// SUPERVISOR_INTERNAL_PORT environment variables for the function.
+	Host string
+	Port string
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	changed   chan struct{}
+	batches   []Batch
+	kills     int
+	logStatus int
+}
+
+// NewSupervisor starts a fake supervisor listening on a local port.
+func NewSupervisor() *Supervisor {
+	s := &Supervisor{
+		changed:   make(chan struct{}),
+		logStatus: http.StatusOK,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ah/log", s.handleLog)
+	mux.HandleFunc("/_ah/kill", s.handleKill)
+	s.srv = httptest.NewServer(mux)
+
+	u, _ := url.Parse(s.srv.URL)
+	s.Host, s.Port, _ = net.SplitHostPort(u.Host)
+
+	return s
+}
+
+// Close shuts down the supervisor.
+func (s *Supervisor) Close() {
+	s.srv.Close()
+}
+
+// Env returns the environment variables telling a function how to reach the
+// supervisor, in the form used by os/exec.
+func (s *Supervisor) Env() []string {
+	return []string{
+		"SUPERVISOR_HOSTNAME=" + s.Host,
+		"SUPERVISOR_INTERNAL_PORT=" + s.Port,
+	}
+}
+
+// SetLogStatus sets the status code returned by /_ah/log, e.g. to simulate a
+// failing supervisor. Batches are recorded whatever the status code.
+func (s *Supervisor) SetLogStatus(code int) {
+	s.mu.Lock()
+	s.logStatus = code
+	s.mu.Unlock()
+}
+
+// Batches returns the log batches received so far.
+func (s *Supervisor) Batches() []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Batch(nil), s.batches...)
+}
+
+// Entries returns the log entries received so far, in order.
+func (s *Supervisor) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []Entry
+	for _, b := range s.batches {
+		entries = append(entries, b.Entries...)
+	}
+	return entries
+}
+
+// Kills returns the number of calls to /_ah/kill received so far.
+func (s *Supervisor) Kills() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kills
+}
+
+// WaitForEntry waits until an entry matching match is received and returns
+// it.
+func (s *Supervisor) WaitForEntry(match func(Entry) bool, timeout time.Duration) (Entry, error) {
+	var found Entry
+	err := s.waitFor(func() bool {
+		for _, b := range s.batches {
+			for _, e := range b.Entries {
+				if match(e) {
+					found = e
+					return true
+				}
+			}
+		}
+		return false
+	}, timeout)
+	return found, err
+}
+
+// WaitForKill waits until the function calls /_ah/kill.
+func (s *Supervisor) WaitForKill(timeout time.Duration) error {
+	return s.waitFor(func() bool { return s.kills > 0 }, timeout)
+}
+
+// waitFor waits until cond, called with the mutex held, returns true.
+func (s *Supervisor) waitFor(cond func() bool, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		ok := cond()
+		changed := s.changed
+		s.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return errors.New("timeout waiting for the supervisor")
+		}
+	}
+}
+
+// notify wakes up the goroutines waiting for a change.
+//
+// Note: notify must be called with the mutex held.
+func (s *Supervisor) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Supervisor) handleLog(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var b Batch
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.batches = append(s.batches, b)
+	status := s.logStatus
+	s.notify()
+	s.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (s *Supervisor) handleKill(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.kills++
+	s.notify()
+	s.mu.Unlock()
+}
+
+// TB is the subset of testing.TB used by the assertions.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// AssertLogged fails the test unless an entry with the given severity and a
+// payload containing substr is received within the timeout. An empty
+// severity matches all entries.
+func (s *Supervisor) AssertLogged(t TB, severity, substr string, timeout time.Duration) Entry {
+	t.Helper()
+
+	e, err := s.WaitForEntry(func(e Entry) bool {
+		return (severity == "" || e.Severity == severity) &&
+			strings.Contains(e.Payload(), substr)
+	}, timeout)
+	if err != nil {
+		t.Fatalf("no %s entry containing %q was logged, got:\n%s", severity, substr, s.dump())
+	}
+	return e
+}
+
+// AssertKilled fails the test unless the function calls /_ah/kill within the
+// timeout.
+func (s *Supervisor) AssertKilled(t TB, timeout time.Duration) {
+	t.Helper()
+
+	if err := s.WaitForKill(timeout); err != nil {
+		t.Fatalf("the function did not call /_ah/kill")
+	}
+}
+
+// AssertNotKilled fails the test if the function called /_ah/kill.
+func (s *Supervisor) AssertNotKilled(t TB) {
+	t.Helper()
+
+	if n := s.Kills(); n > 0 {
+		t.Fatalf("the function called /_ah/kill %d time(s)", n)
+	}
+}
+
+// dump formats the received entries for failure messages.
+func (s *Supervisor) dump() string {
+	var b strings.Builder
+	for _, e := range s.Entries() {
+		fmt.Fprintf(&b, "[%s][%s] %s\n", e.Severity, e.ExecutionID, strings.TrimSpace(e.Payload()))
+	}
+	return b.String()
+}
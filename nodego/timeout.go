@@ -0,0 +1,236 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeoutPolicy tells what happens to a request still running when the
+// request timeout elapses. In all cases, the request context is cancelled and
+// an ERROR entry with the stack of the handler is logged for the execution.
+type TimeoutPolicy string
+
+// Supported timeout policies.
+const (
+	// TimeoutRespond responds right away with the timeout status and the
+	// X-Google-Status header set to "timeout". Responses are buffered until
+	// the handler returns, so handlers can't stream or flush them.
+	TimeoutRespond TimeoutPolicy = "respond"
+	// TimeoutCancel lets the handler respond once it notices that the
+	// context is cancelled. Responses are not buffered. It is the default.
+	TimeoutCancel TimeoutPolicy = "cancel"
+)
+
+// timeoutHandler gives requests a deadline and handles the ones running past
+// it according to its policy.
+type timeoutHandler struct {
+	handler http.Handler
+	timeout time.Duration
+	policy  TimeoutPolicy
+	status  int
+}
+
+// withRequestTimeout returns handler with the request timeout configured by o
+// applied, or handler itself if the timeout is disabled.
+func withRequestTimeout(handler http.Handler, o *options) http.Handler {
+	if o.requestTimeout <= 0 {
+		return handler
+	}
+	return &timeoutHandler{
+		handler: handler,
+		timeout: o.requestTimeout,
+		policy:  o.timeoutPolicy,
+		status:  o.timeoutStatus,
+	}
+}
+
+func (t *timeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), t.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	if t.policy == TimeoutCancel {
+		t.serveCancel(ctx, w, r)
+		return
+	}
+	t.serveRespond(ctx, w, r)
+}
+
+func (t *timeoutHandler) serveCancel(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	g := goroutineID()
+	stop := context.AfterFunc(ctx, func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			t.report(r, g)
+		}
+	})
+	defer stop()
+
+	t.handler.ServeHTTP(w, r)
+}
+
+func (t *timeoutHandler) serveRespond(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	tw := &timeoutWriter{header: make(http.Header)}
+	gc := make(chan string, 1)
+	done := make(chan struct{})
+	panicc := make(chan interface{}, 1)
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicc <- p
+				return
+			}
+			close(done)
+		}()
+		gc <- goroutineID()
+		t.handler.ServeHTTP(tw, r)
+	}()
+
+	select {
+	case p := <-panicc:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+
+		dst := w.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		if tw.code == 0 {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		w.Write(tw.buf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		tw.timedOut = true
+		tw.mu.Unlock()
+
+		if ctx.Err() != context.DeadlineExceeded {
+			// The client went away, there is no one to respond to.
+			return
+		}
+
+		t.report(r, <-gc)
+		SetExecutionStatus(w, StatusTimeout)
+		http.Error(w, "Function execution timed out", t.status)
+	}
+}
+
+// report logs an ERROR entry for the execution of r, with the stack of the
+// goroutine running the handler, and waits for it to be delivered.
+func (t *timeoutHandler) report(r *http.Request, g string) {
+	id := r.Header.Get("Function-Execution-Id")
+
+	msg := fmt.Sprintf("Function execution took longer than %s, finished with status: 'timeout'", t.timeout)
+	if stack := goroutineStack(g); stack != "" {
+		msg += "\n\nThe handler is still running:\n" + stack
+	}
+	ErrorLoggerFromContext(WithExecutionID(r.Context(), id)).Print(msg)
+
+	if err := loggingCtx.flushExecution(id, logFlushTimeout); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+}
+
+// timeoutWriter buffers the response of a handler until it returns. Writes
+// fail with http.ErrHandlerTimeout once the request timed out.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+// Header implements http.ResponseWriter.Header.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write implements http.ResponseWriter.Write.
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// goroutineID returns the ID of the calling goroutine, as shown in stack
+// traces.
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+
+	// The trace starts with "goroutine 123 [running]:".
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return ""
+	}
+	return string(fields[1])
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given ID,
+// or an empty string if it is not running anymore.
+func goroutineStack(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	prefix := "goroutine " + id + " ["
+	for _, trace := range strings.Split(string(buf), "\n\n") {
+		if strings.HasPrefix(trace, prefix) {
+			return trace
+		}
+	}
+	return ""
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stderrCapture collects what is written to os.Stderr, where log entries go
+// when there is no supervisor.
+type stderrCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// captureStderr redirects os.Stderr until the end of the test.
+func captureStderr(t *testing.T) *stderrCapture {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &stderrCapture{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			c.mu.Lock()
+			c.buf.Write(buf[:n])
+			c.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	stderr := os.Stderr
+	os.Stderr = w
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		w.Close()
+		<-done
+		r.Close()
+	})
+	return c
+}
+
+// waitFor returns what was written once it contains s.
+func (c *stderrCapture) waitFor(t *testing.T, s string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		out := c.buf.String()
+		c.mu.Unlock()
+		if strings.Contains(out, s) {
+			return out
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q was not written, got %q", s, out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stuckHandler runs until its context is done and it is released, as a
+// handler ignoring the request timeout would.
+type stuckHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newStuckHandler() *stuckHandler {
+	return &stuckHandler{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (h *stuckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	close(h.started)
+	<-r.Context().Done()
+	<-h.release
+	w.Write([]byte("late"))
+}
+
+// timeoutRequest returns a request for the execution with the given ID.
+func timeoutRequest(id string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Function-Execution-Id", id)
+	return r
+}
+
+// checkTimeoutEntry checks that out holds the ERROR entry logged for the
+// execution with the given ID, with the stack of the stuck handler.
+func checkTimeoutEntry(t *testing.T, out, id string) {
+	t.Helper()
+
+	prefix := "[ERROR]"
+	i := strings.Index(out, prefix)
+	if i < 0 {
+		t.Fatalf("no ERROR entry in %q", out)
+	}
+	entry := out[i:]
+	if !strings.Contains(entry, "]["+id+"] Function execution took longer than 50ms") {
+		t.Errorf("the entry is not attributed to %s: %q", id, entry)
+	}
+	if !strings.Contains(entry, "The handler is still running:\ngoroutine ") || !strings.Contains(entry, "(*stuckHandler).ServeHTTP") {
+		t.Errorf("the entry does not hold the stack of the handler: %q", entry)
+	}
+}
+
+func TestRequestTimeoutDeadline(t *testing.T) {
+	for _, policy := range []TimeoutPolicy{TimeoutCancel, TimeoutRespond} {
+		var deadline time.Time
+		var ok bool
+		handler := withRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok = r.Context().Deadline()
+		}), newOptions([]Option{WithRequestTimeout(time.Minute), WithTimeoutPolicy(policy)}))
+
+		start := time.Now()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		end := time.Now()
+		if !ok {
+			t.Errorf("%s: the request has no deadline", policy)
+			continue
+		}
+		if deadline.Before(start.Add(time.Minute)) || deadline.After(end.Add(time.Minute)) {
+			t.Errorf("%s: got deadline in %v, want in 1m", policy, deadline.Sub(start))
+		}
+	}
+}
+
+func TestRequestTimeoutDisabled(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if got := withRequestTimeout(h, newOptions([]Option{WithRequestTimeout(0)})); got == nil {
+		t.Fatal("got no handler")
+	} else if _, ok := got.(*timeoutHandler); ok {
+		t.Error("the timeout is applied with WithRequestTimeout(0)")
+	}
+}
+
+func TestTimeoutRespond(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		status int
+	}{
+		{"default status", nil, http.StatusGatewayTimeout},
+		{"WithTimeoutStatus", []Option{WithTimeoutStatus(http.StatusRequestTimeout)}, http.StatusRequestTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureStderr(t)
+			stuck := newStuckHandler()
+			defer close(stuck.release)
+
+			opts := append([]Option{WithRequestTimeout(50 * time.Millisecond), WithTimeoutPolicy(TimeoutRespond)}, tt.opts...)
+			handler := withRequestTimeout(WithExecutionStatus(stuck), newOptions(opts))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, timeoutRequest("exec-respond"))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get(functionStatusHeaderField); got != string(StatusTimeout) {
+				t.Errorf("got %s %q, want %q", functionStatusHeaderField, got, StatusTimeout)
+			}
+			if strings.Contains(rec.Body.String(), "late") {
+				t.Errorf("the late response of the handler was written: %q", rec.Body.String())
+			}
+			checkTimeoutEntry(t, out.waitFor(t, "stuckHandler"), "exec-respond")
+		})
+	}
+}
+
+func TestTimeoutCancelReports(t *testing.T) {
+	out := captureStderr(t)
+	stuck := newStuckHandler()
+	handler := withRequestTimeout(WithExecutionStatus(stuck), newOptions([]Option{WithRequestTimeout(50 * time.Millisecond)}))
+
+	rec := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, timeoutRequest("exec-cancel"))
+		close(served)
+	}()
+	<-stuck.started
+
+	// The entry is logged while the handler is still running.
+	checkTimeoutEntry(t, out.waitFor(t, "stuckHandler"), "exec-cancel")
+	close(stuck.release)
+	<-served
+
+	if got := rec.Body.String(); got != "late" {
+		t.Errorf("got body %q, want the response of the handler", got)
+	}
+}
+
+func TestTimeoutRespondClientGone(t *testing.T) {
+	stuck := newStuckHandler()
+	defer close(stuck.release)
+	handler := withRequestTimeout(stuck, newOptions([]Option{WithRequestTimeout(time.Minute), WithTimeoutPolicy(TimeoutRespond)}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, timeoutRequest("exec-gone").WithContext(ctx))
+		close(served)
+	}()
+	<-stuck.started
+	cancel()
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not abandoned when its client went away")
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("got a response with status %d and body %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDefaultTimeoutPolicyFlushes(t *testing.T) {
+	handler := withRequestTimeout(WithExecutionStatus(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+	})), newOptions(nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if !rec.Flushed {
+		t.Error("the response was not flushed")
+	}
+	if got := rec.Body.String(); got != "partial" {
+		t.Errorf("got body %q, want %q", got, "partial")
+	}
+}
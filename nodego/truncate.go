@@ -0,0 +1,185 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodego
+
+import (
+	"encoding/json"
+	"sort"
+	"unicode/utf8"
+)
+
+// Limits on the metadata of an entry, in encoded bytes. Along with
+// maxLogLength, they keep every entry well below maxLogBatchLength:
+// 64 labels of 1152 bytes, 4 fields of 1024 bytes and a text payload of at
+// most 6 times maxLogLength once escaped make about 110000 bytes.
+const (
+	maxLogLabels           = 64
+	maxLogLabelKeyLength   = 128
+	maxLogLabelValueLength = 1024
+	// maxLogFieldLength bounds the severity, execution ID, trace and source
+	// location of an entry.
+	maxLogFieldLength = 1024
+	// minTruncatedLength is the length below which a payload field is
+	// removed rather than truncated.
+	minTruncatedLength = 64
+)
+
+// truncationMarker ends the values that were truncated to fit in an entry.
+const truncationMarker = "...[truncated]"
+
+// truncateMetadata shortens the fields of the entry other than its payload to
+// the limits above. The labels are copied before being modified.
+func (e *logEntry) truncateMetadata() {
+	e.Severity = truncateJSONString(e.Severity, maxLogFieldLength)
+	e.ExecutionID = truncateJSONString(e.ExecutionID, maxLogFieldLength)
+	e.Trace = truncateJSONString(e.Trace, maxLogFieldLength)
+
+	if sl := e.SourceLocation; sl != nil {
+		e.SourceLocation = &sourceLocation{
+			File:     truncateJSONString(sl.File, maxLogFieldLength),
+			Line:     sl.Line,
+			Function: truncateJSONString(sl.Function, maxLogFieldLength),
+		}
+	}
+
+	if !labelsNeedTruncation(e.Labels) {
+		return
+	}
+
+	keys := make([]string, 0, len(e.Labels))
+	for k := range e.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > maxLogLabels {
+		keys = keys[:maxLogLabels]
+	}
+
+	labels := make(map[string]string, len(keys))
+	for _, k := range keys {
+		labels[truncateJSONString(k, maxLogLabelKeyLength)] = truncateJSONString(e.Labels[k], maxLogLabelValueLength)
+	}
+	e.Labels = labels
+}
+
+// labelsNeedTruncation reports whether labels exceed the limits above.
+func labelsNeedTruncation(labels map[string]string) bool {
+	if len(labels) > maxLogLabels {
+		return true
+	}
+	for k, v := range labels {
+		if jsonStringLength(k) > maxLogLabelKeyLength || jsonStringLength(v) > maxLogLabelValueLength {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateFields shortens the largest fields of a JSON object until it
+// encodes to at most max bytes. Values that are not strings are replaced by
+// their truncated JSON text, and fields that are already short are removed
+// if it is not enough.
+func truncateFields(fields map[string]json.RawMessage, max int) {
+	for {
+		over := jsonObjectLength(fields) - max
+		if over <= 0 {
+			return
+		}
+
+		var key string
+		for k, v := range fields {
+			if key == "" || len(v) > len(fields[key]) || (len(v) == len(fields[key]) && k < key) {
+				key = k
+			}
+		}
+
+		raw := fields[key]
+		if len(raw) <= minTruncatedLength {
+			delete(fields, key)
+			continue
+		}
+		target := len(raw) - over
+		if target < minTruncatedLength {
+			// Leave room to the other fields.
+			target = minTruncatedLength
+		}
+
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			s = string(raw)
+		}
+		fields[key], _ = json.Marshal(truncateJSONString(s, target))
+	}
+}
+
+// jsonObjectLength returns the length of fields encoded as a JSON object.
+func jsonObjectLength(fields map[string]json.RawMessage) int {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// truncateJSONString shortens s so that it encodes to at most max bytes,
+// quotes included, ending it with truncationMarker if it was cut.
+func truncateJSONString(s string, max int) string {
+	if jsonStringLength(s) <= max {
+		return s
+	}
+	head, _ := cutJSONString(s, max-len(truncationMarker))
+	return head + truncationMarker
+}
+
+// cutJSONString cuts s after its longest prefix that encodes to at most max
+// bytes, quotes included, without splitting a character.
+func cutJSONString(s string, max int) (head, tail string) {
+	n := len(`""`)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		n += jsonCharLength(r, size)
+		if n > max {
+			return s[:i], s[i:]
+		}
+		i += size
+	}
+	return s, ""
+}
+
+// jsonStringLength returns the length of s encoded as a JSON string.
+func jsonStringLength(s string) int {
+	n := len(`""`)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		n += jsonCharLength(r, size)
+		i += size
+	}
+	return n
+}
+
+// jsonCharLength returns the length of the character r, whose UTF-8 encoding
+// is size bytes long, in a string encoded by encoding/json. It may
+// overestimate the length of control characters.
+func jsonCharLength(r rune, size int) int {
+	switch {
+	case r == utf8.RuneError && size == 1:
+		return len(`\ufffd`)
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < ' ' || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+		return len(`\u0000`)
+	}
+	return size
+}
@@ -17,7 +17,7 @@ const port = 8080;
 
 const requestHandler = function(req, res) {
 	console.log(req.url);
-	_ = require("./node_modules/execer/build/Release/execer.node");
+	_ = require("./node_modules/execer");
 	res.end("it didn't work :(");
 }
 